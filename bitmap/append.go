@@ -0,0 +1,79 @@
+package bitmap
+
+import "fmt"
+
+// End returns the index at which the next bit will be written by the Append
+// methods.
+//
+// It starts out at the size the bitmap was created with. Bits set with Set
+// do not affect it.
+func (bm *Bitmap) End() int {
+	return bm.end
+}
+
+// AppendBit writes a single bit at the end of the bitmap.
+//
+// An error is returned if val is neither 0 nor 1, or if the bitmap is at
+// capacity.
+func (bm *Bitmap) AppendBit(val byte) error {
+	if !(val == 0 || val == 1) {
+		return fmt.Errorf("Val must be one of 0, 1. Was %d", val)
+	}
+
+	return bm.AppendBits(uint64(val), 1)
+}
+
+// AppendBits writes the last n bits of word at the end of the bitmap, most
+// significant bit first.
+//
+// Rather than setting the bits one by one, they are written to at most two
+// uint64s at once. Any bits previously set in their place are overwritten.
+//
+// An error is returned if n is not in [0, 64], or if the bits would exceed
+// the bitmap's capacity.
+func (bm *Bitmap) AppendBits(word uint64, n int) error {
+	if n < 0 || n > 64 {
+		return fmt.Errorf("Number of bits must be in [0, 64]. Was %d", n)
+	}
+
+	if bm.end+n > bm.Capacity {
+		return fmt.Errorf("Cannot append %d bits at %d, capacity is %d", n, bm.end, bm.Capacity)
+	}
+
+	if n == 0 {
+		return nil
+	}
+
+	bm.resize(bm.end + n)
+	bm.writeBits(bm.end, word, n)
+	bm.end += n
+
+	return nil
+}
+
+// AppendZeros writes n 0-bits at the end of the bitmap.
+//
+// An error is returned if the bits would exceed the bitmap's capacity.
+func (bm *Bitmap) AppendZeros(n int) error {
+	if n < 0 {
+		return fmt.Errorf("Number of bits must not be negative. Was %d", n)
+	}
+
+	if bm.end+n > bm.Capacity {
+		return fmt.Errorf("Cannot append %d bits at %d, capacity is %d", n, bm.end, bm.Capacity)
+	}
+
+	for n > 0 {
+		// Writing up to the next word boundary keeps all but the first
+		// write aligned.
+		chunk := 64 - bm.end%64
+		if n < chunk {
+			chunk = n
+		}
+
+		bm.AppendBits(0, chunk)
+		n -= chunk
+	}
+
+	return nil
+}
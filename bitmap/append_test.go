@@ -0,0 +1,130 @@
+package bitmap
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAppendBits(t *testing.T) {
+	rng := rand.New(rand.NewSource(42))
+
+	bm := New(0, 10_000)
+	expected := make([]byte, 0)
+
+	for bm.End() < 9_000 {
+		n := rng.Intn(65)
+		word := rng.Uint64()
+
+		assert.Nil(t, bm.AppendBits(word, n))
+		for i := n - 1; i >= 0; i-- {
+			expected = append(expected, byte(word>>i&1))
+		}
+
+		// Interleave the other ways of appending
+		switch rng.Intn(3) {
+		case 0:
+			bit := byte(rng.Intn(2))
+			assert.Nil(t, bm.AppendBit(bit))
+			expected = append(expected, bit)
+		case 1:
+			zeros := rng.Intn(150)
+			assert.Nil(t, bm.AppendZeros(zeros))
+			expected = append(expected, make([]byte, zeros)...)
+		}
+	}
+
+	assert.Equal(t, len(expected), bm.End())
+	for i, val := range expected {
+		actual, err := bm.Get(i)
+		assert.Nil(t, err)
+		assert.Equal(t, val, actual, "Bit %d", i)
+	}
+}
+
+func TestAppendAfterNew(t *testing.T) {
+	bm := New(70, 256)
+	assert.Equal(t, 70, bm.End())
+
+	assert.Nil(t, bm.AppendBits(0b11, 2))
+	assert.Equal(t, 72, bm.End())
+
+	for i := 0; i < 72; i++ {
+		val, _ := bm.Get(i)
+		assert.Equal(t, byte(i/70), val, "Bit %d", i)
+	}
+}
+
+func TestAppendOverwrites(t *testing.T) {
+	bm := New(0, 128)
+	for i := 0; i < 128; i++ {
+		assert.Nil(t, bm.Set(i))
+	}
+
+	assert.Nil(t, bm.AppendBits(0b1010, 4))
+	assert.Nil(t, bm.AppendZeros(100))
+	assert.Nil(t, bm.AppendBit(0))
+	assert.Equal(t, 105, bm.End())
+
+	for i := 0; i < 128; i++ {
+		expected := byte(0)
+		if i == 0 || i == 2 || i >= 105 {
+			expected = 1
+		}
+
+		val, _ := bm.Get(i)
+		assert.Equal(t, expected, val, "Bit %d", i)
+	}
+}
+
+func TestAppendInvalid(t *testing.T) {
+	bm := New(0, 100)
+
+	assert.Error(t, bm.AppendBit(2))
+	assert.Error(t, bm.AppendBits(0, -1))
+	assert.Error(t, bm.AppendBits(0, 65))
+	assert.Error(t, bm.AppendZeros(-1))
+
+	// Exceeding the capacity leaves the bitmap unchanged
+	assert.Nil(t, bm.AppendZeros(90))
+	assert.Error(t, bm.AppendBits(0, 11))
+	assert.Error(t, bm.AppendZeros(11))
+	assert.Equal(t, 90, bm.End())
+
+	assert.Nil(t, bm.AppendBits(0x3FF, 10))
+	assert.Error(t, bm.AppendBit(1))
+	assert.Equal(t, 100, bm.End())
+	assert.Equal(t, 10, bm.PopCount())
+}
+
+func BenchmarkAppendBits(b *testing.B) {
+	bm := New(0, 64*b.N)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		err := bm.AppendBits(0xDEADBEEFDEADBEEF, 64)
+		if err != nil {
+			b.Errorf("Error while appending bits: %v", err)
+		}
+	}
+}
+
+// BenchmarkAppendBitsWithSet is the baseline of BenchmarkAppendBits, setting
+// the bits one at a time.
+func BenchmarkAppendBitsWithSet(b *testing.B) {
+	bm := New(0, 64*b.N)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var word uint64 = 0xDEADBEEFDEADBEEF
+		for bit := 0; bit < 64; bit++ {
+			if word>>(63-bit)&1 == 1 {
+				err := bm.Set(64*i + bit)
+				if err != nil {
+					b.Errorf("Error while setting bit %d: %v", 64*i+bit, err)
+				}
+			}
+		}
+	}
+}
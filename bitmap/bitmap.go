@@ -1,9 +1,11 @@
 package bitmap
 
 import (
+	"encoding/binary"
 	"fmt"
 	"math"
 	"math/bits"
+	"unsafe"
 
 	"github.com/Lavode/surf/bitops"
 )
@@ -15,40 +17,50 @@ import (
 // position, respectively find the position of the i-th 0 and 1.
 //
 // Under the hood it is implemented as a slice of int64 which grows as
-// required.
+// required. Its memory is reserved lazily, doubling as needed up to the
+// capacity, such that a generous capacity costs nothing until it is used.
 type Bitmap struct {
 	// Capacity is the number of bits the bitmap allows to access
 	Capacity int
 	// length is the number of bits accessible without a resize
 	length int
-	data   []uint64
+	// end is the index at which the Append methods write the next bit
+	end  int
+	data []uint64
+	// ranks is the rank index built by BuildRankIndex, or nil if there is
+	// none.
+	ranks []int
 }
 
 // New initializes a new bitmap.
 //
 // Capacity specifies the maximum size of the bitmap in bits. Thus the
-// addressable bits will be in the closed interval [0, capacity - 1].
+// addressable bits will be in the closed interval [0, capacity - 1]. Memory is
+// only reserved for the initial size, and grown as bits are accessed.
 //
 // size specifies the size with which the bitmap will be initialized, in bits.
+// Bits added by the Append methods are placed after these.
 func New(size, capacity int) *Bitmap {
-	// We can fit 64 bits into each uint64.
+	// We can fit 64 bits into each uint64, but have to round up, in case
+	// it's not a multiple of 64.
 	dataSize := size / 64
-	dataCapacity := capacity / 64
-
-	// But have to round up, in case it's not a multiple of 64
 	if size%64 != 0 {
 		dataSize++
 	}
-	if capacity%64 != 0 {
-		dataCapacity++
-	}
 
-	data := make([]uint64, dataSize, dataCapacity)
-	bm := Bitmap{Capacity: capacity, length: dataSize * 64, data: data}
+	data := make([]uint64, dataSize)
+	bm := Bitmap{Capacity: capacity, length: dataSize * 64, end: size, data: data}
 
 	return &bm
 }
 
+// Len returns the number of bits which are accessible without a resize.
+//
+// As bits are stored in uint64s, this is always a multiple of 64.
+func (bm *Bitmap) Len() int {
+	return bm.length
+}
+
 // Set sets the bit at a given index to 1.
 //
 // An error is returned if the index is invalid.
@@ -66,6 +78,7 @@ func (bm *Bitmap) Set(bit int) error {
 
 	mask := bitops.SingleOneMask(offset)
 	bm.data[idx] = bm.data[idx] | mask
+	bm.dropRankIndex()
 
 	return nil
 }
@@ -87,6 +100,7 @@ func (bm *Bitmap) Unset(bit int) error {
 
 	mask := bitops.OnesMask(offset, 64-offset-1) // 64 1s, except for one 0 at offset
 	bm.data[idx] = bm.data[idx] & mask
+	bm.dropRankIndex()
 
 	return nil
 }
@@ -120,6 +134,9 @@ func (bm *Bitmap) Get(bit int) (byte, error) {
 // As an example, Select(0, 13) would return the index of the 13th 0-bit in the
 // bitmap.
 //
+// With a rank index, the scan starts at the sample holding the bit, found by
+// binary search, rather than at the start of the bitmap.
+//
 // An error is returned if there is no nth bit of value val in the bitmap, or
 // if val is neither 0 nor 1.
 func (bm *Bitmap) Select(val, nth int) (int, error) {
@@ -134,8 +151,15 @@ func (bm *Bitmap) Select(val, nth int) (int, error) {
 	checkOnes := val == 1
 
 	count := 0
+	start := 0
+	if bm.ranks != nil {
+		var word int
+		word, count = bm.indexedSelectStart(val, nth)
+		start = 64 * word
+	}
+
 	var idx int
-	for idx = 0; idx < bm.length; idx += 64 {
+	for idx = start; idx < bm.length; idx += 64 {
 		var additionalCount int
 		if checkOnes {
 			additionalCount = bits.OnesCount64(bm.data[idx/64])
@@ -157,33 +181,22 @@ func (bm *Bitmap) Select(val, nth int) (int, error) {
 	}
 
 	// At this point we either a) ran out of data or b) need to consider
-	// the most recent uint64 bit by bit.
+	// the most recent uint64.
 
 	// Case 1: We ran out of data.
-	if idx > bm.length {
+	if idx >= bm.length {
 		return 0, fmt.Errorf("Bitmap only contained %d bits of value %d", count, val)
 	}
 
 	// Case 2: We bailed out as we'd have overshot. idx currently points to
-	// the first bit of the most-recently-considered uint64.
-	// We'll go through the current uint64 bit by bit until we get to the
-	// desired count.
-	for count < nth {
-		val, err := bm.Get(idx)
-		if err != nil {
-			return 0, err
-		}
-
-		if (val == 1 && checkOnes) || (val == 0 && !checkOnes) {
-			count += 1
-		}
-
-		idx += 1
+	// the first bit of the most-recently-considered uint64, which holds
+	// the desired bit.
+	word := bm.data[idx/64]
+	if !checkOnes {
+		word = ^word
 	}
 
-	// idx is one too high as we increased it one last time in the last
-	// iteration of the loop
-	return idx - 1, nil
+	return idx + bitops.Select64(word, nth-count), nil
 }
 
 // Rank returns the number of bits with value val, up to and including
@@ -192,6 +205,9 @@ func (bm *Bitmap) Select(val, nth int) (int, error) {
 // As an example. Rank(1, 42) would return the number of 1-bits up to and
 // including bit 42.
 //
+// With a rank index, only the bits following the closest sample are counted,
+// rather than those from the start of the bitmap.
+//
 // An error is returned if the index is outside the range of the bitmap, or if
 // val is neither 0 nor 1.
 func (bm *Bitmap) Rank(val, idx int) (int, error) {
@@ -204,6 +220,14 @@ func (bm *Bitmap) Rank(val, idx int) (int, error) {
 	}
 	checkOnes := val == 1
 
+	if bm.ranks != nil {
+		ones := bm.indexedOnesBefore(idx) + int(bm.data[idx/64]>>(63-idx%64)&1)
+		if checkOnes {
+			return ones, nil
+		}
+		return idx + 1 - ones, nil
+	}
+
 	cnt := 0
 	for i := 0; i <= idx; i += 64 {
 		var onesCount int
@@ -215,8 +239,7 @@ func (bm *Bitmap) Rank(val, idx int) (int, error) {
 			onesCount = bits.OnesCount64(bm.data[i/64])
 		} else {
 			// Wheras here we only care about the first (idx-i)+1 bits
-			mask := bitops.LeadingOnesMask(idx - i + 1)
-			onesCount = bits.OnesCount64(bm.data[i/64] & mask)
+			onesCount = bitops.Rank64(bm.data[i/64], idx-i)
 		}
 
 		if checkOnes {
@@ -235,6 +258,131 @@ func (bm *Bitmap) Rank(val, idx int) (int, error) {
 	return cnt, nil
 }
 
+// NextOne returns the index of the first 1-bit at or after position from.
+//
+// Rather than checking bits one by one, it skips over whole uint64s of 0-bits
+// and counts the leading zeroes of the first non-zero one. If there is no
+// 1-bit at or after from within the bitmap's length, false is returned.
+func (bm *Bitmap) NextOne(from int) (int, bool) {
+	return bm.next(from, 0)
+}
+
+// NextZero returns the index of the first 0-bit at or after position from.
+//
+// It works like NextOne. If there is no 0-bit at or after from within the
+// bitmap's length, false is returned.
+func (bm *Bitmap) NextZero(from int) (int, bool) {
+	return bm.next(from, math.MaxUint64)
+}
+
+// next returns the index of the first 1-bit at or after position from, of
+// the bitmap's uint64s XORed with flip.
+func (bm *Bitmap) next(from int, flip uint64) (int, bool) {
+	if from < 0 {
+		from = 0
+	}
+	if from >= bm.length {
+		return 0, false
+	}
+
+	// Bits are stored from the most significant bit onwards, so shifting
+	// left discards those before from.
+	idx := from / 64
+	word := (bm.data[idx] ^ flip) << (from % 64)
+	if word != 0 {
+		return from + bits.LeadingZeros64(word), true
+	}
+
+	for idx++; idx < bm.length/64; idx++ {
+		word = bm.data[idx] ^ flip
+		if word != 0 {
+			return 64*idx + bits.LeadingZeros64(word), true
+		}
+	}
+
+	return 0, false
+}
+
+// PrevOne returns the index of the last 1-bit at or before position from.
+//
+// It works like NextOne, but scans towards the start of the bitmap. If there
+// is no 1-bit at or before from, false is returned.
+func (bm *Bitmap) PrevOne(from int) (int, bool) {
+	if from >= bm.length {
+		from = bm.length - 1
+	}
+	if from < 0 {
+		return 0, false
+	}
+
+	// Masking discards the bits after from.
+	idx := from / 64
+	word := bitops.FirstBits(from%64+1, bm.data[idx])
+	if word != 0 {
+		return 64*idx + 63 - bits.TrailingZeros64(word), true
+	}
+
+	for idx--; idx >= 0; idx-- {
+		if bm.data[idx] != 0 {
+			return 64*idx + 63 - bits.TrailingZeros64(bm.data[idx]), true
+		}
+	}
+
+	return 0, false
+}
+
+// ForEachOne calls f with the index of each 1-bit, in increasing order.
+//
+// Iteration stops early if f returns false.
+func (bm *Bitmap) ForEachOne(f func(i int) bool) {
+	for idx := 0; idx < bm.length/64; idx++ {
+		word := bm.data[idx]
+		for word != 0 {
+			offset := bits.LeadingZeros64(word)
+			if !f(64*idx + offset) {
+				return
+			}
+
+			word &^= bitops.SingleOneMask(offset)
+		}
+	}
+}
+
+// CountOnes returns the number of 1-bits in the range [from, to).
+//
+// Unlike Rank, it only considers the uint64s overlapping the range, so is
+// cheap for short ranges anywhere in the bitmap. With a rank index, long
+// ranges are cheap as well.
+//
+// An error is returned if the range is not within [0, Len()].
+func (bm *Bitmap) CountOnes(from, to int) (int, error) {
+	if from < 0 || to > bm.length || from > to {
+		return 0, fmt.Errorf("Range [%d, %d) must be within [0, %d)", from, to, bm.length)
+	}
+
+	if bm.ranks != nil && to-from > 64*rankSampleWords {
+		return bm.indexedOnesBefore(to) - bm.indexedOnesBefore(from), nil
+	}
+
+	cnt := 0
+	for from < to {
+		offset := from % 64
+
+		// Number of bits of the range within the current uint64
+		n := 64 - offset
+		if to-from < n {
+			n = to - from
+		}
+
+		word := bm.data[from/64] << offset
+		cnt += bits.OnesCount64(bitops.FirstBits(n, word))
+
+		from += n
+	}
+
+	return cnt, nil
+}
+
 // String returs a representation of the bitmap's contents as a string of bits.
 //
 // This means that each stored bit will be encoded as either a 0 or 1 ASCII
@@ -288,6 +436,56 @@ func (bm *Bitmap) Equal(other *Bitmap) bool {
 	return true
 }
 
+// MarshalBinary encodes the bitmap's content.
+//
+// The encoding consists of the bitmap's length in bits, followed by its
+// content, as big-endian uint64s. The capacity is not encoded.
+func (bm *Bitmap) MarshalBinary() ([]byte, error) {
+	out := make([]byte, 8+8*len(bm.data))
+
+	binary.BigEndian.PutUint64(out, uint64(bm.length))
+	for i, word := range bm.data {
+		binary.BigEndian.PutUint64(out[8+8*i:], word)
+	}
+
+	return out, nil
+}
+
+// UnmarshalBinary decodes a bitmap previously encoded with MarshalBinary,
+// replacing the bitmap's content.
+//
+// As the capacity is not encoded, it is set to the bitmap's length. Neither is
+// the position End at which the Append methods write, so round-tripping resets
+// it to the length as well. Bits appended after decoding thus follow the
+// padding to the next multiple of 64 bits, rather than the last bit appended
+// before encoding.
+//
+// The decoded bitmap has a rank index, as built by BuildRankIndex.
+//
+// An error is returned if the encoding is malformed.
+func (bm *Bitmap) UnmarshalBinary(data []byte) error {
+	if len(data) < 8 || len(data)%8 != 0 {
+		return fmt.Errorf("Encoded bitmap must be a multiple of 8 bytes, and at least 8 bytes long. Was %d", len(data))
+	}
+
+	words := len(data)/8 - 1
+	length := binary.BigEndian.Uint64(data)
+	if length != uint64(64*words) {
+		return fmt.Errorf("Encoded bitmap length %d does not match its %d words of content", length, words)
+	}
+
+	bm.data = make([]uint64, words)
+	for i := range bm.data {
+		bm.data[i] = binary.BigEndian.Uint64(data[8+8*i:])
+	}
+	bm.length = 64 * words
+	bm.end = bm.length
+	bm.Capacity = bm.length
+	bm.BuildRankIndex()
+
+	return nil
+}
+
 // resize will increase the bitmap's internal memory such that it can accomodate
 // a given number of bits.
 //
@@ -309,10 +507,61 @@ func (bm *Bitmap) resize(bit int) {
 		newLength++
 	}
 
-	additionalUints := newLength - len(bm.data)
-	for i := 0; i < additionalUints; i++ {
-		bm.data = append(bm.data, uint64(0))
+	if newLength > cap(bm.data) {
+		// Doubling the memory keeps the cost of growing amortised
+		// constant, but must not reserve more than the capacity
+		// allows for.
+		newCap := 2 * cap(bm.data)
+		if newCap < newLength {
+			newCap = newLength
+		}
+		if maxCap := bm.capacityWords(); newCap > maxCap {
+			newCap = maxCap
+		}
+
+		data := make([]uint64, len(bm.data), newCap)
+		copy(data, bm.data)
+		bm.data = data
+	}
+
+	// Words past the length may hold stale bits, e.g. if the bitmap was
+	// resliced, so we clear them.
+	old := len(bm.data)
+	bm.data = bm.data[:newLength]
+	for i := old; i < newLength; i++ {
+		bm.data[i] = 0
 	}
 
 	bm.length = newLength * 64
+	bm.dropRankIndex()
+}
+
+// capacityWords returns the number of uint64s needed to hold Capacity bits.
+func (bm *Bitmap) capacityWords() int {
+	words := bm.Capacity / 64
+	if bm.Capacity%64 != 0 {
+		words++
+	}
+
+	return words
+}
+
+// ShrinkToFit releases memory reserved for growth, such that the bitmap only
+// occupies as much memory as its current length requires.
+//
+// The capacity is not affected, so the bitmap may still grow later on.
+func (bm *Bitmap) ShrinkToFit() {
+	if cap(bm.data) == len(bm.data) {
+		return
+	}
+
+	data := make([]uint64, len(bm.data))
+	copy(data, bm.data)
+	bm.data = data
+}
+
+// SizeBytes returns the number of bytes of memory occupied by the bitmap,
+// including memory reserved for growth and its rank index.
+func (bm *Bitmap) SizeBytes() int {
+	return int(unsafe.Sizeof(*bm)) + 8*cap(bm.data) + int(unsafe.Sizeof(int(0)))*cap(bm.ranks)
 }
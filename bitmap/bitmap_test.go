@@ -1,6 +1,7 @@
 package bitmap
 
 import (
+	"math/rand"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -9,18 +10,26 @@ import (
 func TestNew(t *testing.T) {
 	bm := New(128, 256)
 	assert.Equal(t, 2, len(bm.data))
-	assert.Equal(t, 4, cap(bm.data))
+	assert.Equal(t, 2, cap(bm.data))
 	assert.Equal(t, 256, bm.Capacity)
 	assert.Equal(t, 128, bm.length)
 
 	// This one will trigger rounding up
 	bm = New(129, 257)
 	assert.Equal(t, 3, len(bm.data))
-	assert.Equal(t, 5, cap(bm.data))
+	assert.Equal(t, 3, cap(bm.data))
 	assert.Equal(t, 257, bm.Capacity)
 	assert.Equal(t, 192, bm.length)
 }
 
+func TestLen(t *testing.T) {
+	bm := New(100, 1024)
+	assert.Equal(t, 128, bm.Len())
+
+	assert.Nil(t, bm.Set(200))
+	assert.Equal(t, 256, bm.Len())
+}
+
 func TestSet(t *testing.T) {
 	// A brief implementation-aware test, to ensure we actually set bits
 	// the way we think we do.
@@ -103,6 +112,47 @@ func TestResize(t *testing.T) {
 	}
 }
 
+func TestResizeDoubles(t *testing.T) {
+	bm := New(64, 64*100)
+
+	allocations := 0
+	for bit := 0; bit < bm.Capacity; bit++ {
+		before := cap(bm.data)
+		assert.Nil(t, bm.Set(bit))
+
+		if cap(bm.data) != before {
+			allocations++
+		}
+		assert.LessOrEqual(t, cap(bm.data), 100)
+	}
+
+	// 1, 2, 4, ..., 64, and the capped 100
+	assert.Equal(t, 7, allocations)
+	assert.Equal(t, 100, cap(bm.data))
+	assert.Equal(t, uint64(0xFFFFFFFFFFFFFFFF), bm.data[99])
+}
+
+func TestShrinkToFit(t *testing.T) {
+	bm := New(0, 1_000_000)
+	empty := bm.SizeBytes()
+
+	for bit := 0; bit < 64*33; bit += 7 {
+		assert.Nil(t, bm.Set(bit))
+	}
+	assert.Equal(t, 64, cap(bm.data))
+	assert.Equal(t, empty+8*64, bm.SizeBytes())
+
+	expected := bm.clone(bm.Capacity)
+	bm.ShrinkToFit()
+	assert.Equal(t, 33, cap(bm.data))
+	assert.Equal(t, empty+8*33, bm.SizeBytes())
+	assert.True(t, expected.Equal(bm))
+
+	// The bitmap may still grow
+	assert.Nil(t, bm.Set(999_999))
+	assert.Equal(t, 1_000_000/64, len(bm.data))
+}
+
 func TestRank(t *testing.T) {
 	bm := New(128, 128)
 	bm.data = []uint64{
@@ -189,6 +239,30 @@ func TestSelect(t *testing.T) {
 	}
 }
 
+func TestRankSelectAgainstReference(t *testing.T) {
+	for _, bm := range randomBitmaps() {
+		counts := [2]int{}
+		for i := 0; i < bm.Len(); i++ {
+			val, err := bm.Get(i)
+			assert.Nil(t, err)
+			counts[val]++
+
+			selected, err := bm.Select(int(val), counts[val])
+			assert.Nil(t, err)
+			assert.Equal(t, i, selected, "Select(%d, %d)", val, counts[val])
+
+			rank, err := bm.Rank(int(val), i)
+			assert.Nil(t, err)
+			assert.Equal(t, counts[val], rank, "Rank(%d, %d)", val, i)
+		}
+
+		for val, cnt := range counts {
+			_, err := bm.Select(val, cnt+1)
+			assert.Error(t, err, "Select(%d, %d) past the last bit", val, cnt+1)
+		}
+	}
+}
+
 func TestSelectInvalidArguments(t *testing.T) {
 	bm := New(128, 128)
 
@@ -207,6 +281,162 @@ func TestSelectInvalidArguments(t *testing.T) {
 	assert.Error(t, err)
 }
 
+func TestNextOne(t *testing.T) {
+	bm := New(256, 256)
+	bm.data = []uint64{
+		0x0000000000000000,
+		0x1000000000000001,
+		0x0000000000000000,
+		0x8000000000000000,
+	}
+
+	tests := []struct {
+		from  int
+		next  int
+		found bool
+	}{
+		{-5, 67, true},
+		{0, 67, true},
+		{67, 67, true},
+		{68, 127, true},
+		{127, 127, true},
+		{128, 192, true},
+		{192, 192, true},
+		{193, 0, false},
+		{256, 0, false},
+	}
+
+	for _, test := range tests {
+		next, found := bm.NextOne(test.from)
+		assert.Equal(t, test.found, found, "NextOne(%d)", test.from)
+		assert.Equal(t, test.next, next, "NextOne(%d)", test.from)
+	}
+}
+
+// randomBitmaps returns bitmaps of varying lengths and densities, including
+// empty and full ones.
+func randomBitmaps() []*Bitmap {
+	rng := rand.New(rand.NewSource(42))
+
+	bitmaps := make([]*Bitmap, 0)
+	for _, size := range []int{0, 64, 128, 320} {
+		for _, density := range []float64{0, 0.01, 0.5, 0.99, 1} {
+			bm := New(size, size)
+			for i := 0; i < size; i++ {
+				if rng.Float64() < density {
+					bm.Set(i)
+				}
+			}
+
+			bitmaps = append(bitmaps, bm)
+		}
+	}
+
+	return bitmaps
+}
+
+// scan is the bit-by-bit reference of NextOne, NextZero and PrevOne. It
+// returns the first bit with value val, starting at from and moving by step.
+func scan(bm *Bitmap, from, step int, val byte) (int, bool) {
+	for i := from; i >= 0 && i < bm.Len(); i += step {
+		bit, _ := bm.Get(i)
+		if bit == val {
+			return i, true
+		}
+	}
+
+	return 0, false
+}
+
+func TestScanningAgainstReference(t *testing.T) {
+	for _, bm := range randomBitmaps() {
+		for from := -2; from < bm.Len()+2; from++ {
+			start := from
+			if start < 0 {
+				start = 0
+			}
+			next, found := scan(bm, start, 1, 1)
+			actual, actualFound := bm.NextOne(from)
+			assert.Equal(t, found, actualFound, "NextOne(%d)", from)
+			assert.Equal(t, next, actual, "NextOne(%d)", from)
+
+			next, found = scan(bm, start, 1, 0)
+			actual, actualFound = bm.NextZero(from)
+			assert.Equal(t, found, actualFound, "NextZero(%d)", from)
+			assert.Equal(t, next, actual, "NextZero(%d)", from)
+
+			start = from
+			if start >= bm.Len() {
+				start = bm.Len() - 1
+			}
+			prev, found := scan(bm, start, -1, 1)
+			actual, actualFound = bm.PrevOne(from)
+			assert.Equal(t, found, actualFound, "PrevOne(%d)", from)
+			assert.Equal(t, prev, actual, "PrevOne(%d)", from)
+		}
+	}
+}
+
+func TestForEachOne(t *testing.T) {
+	for _, bm := range randomBitmaps() {
+		expected := make([]int, 0)
+		for i := 0; i < bm.Len(); i++ {
+			bit, _ := bm.Get(i)
+			if bit == 1 {
+				expected = append(expected, i)
+			}
+		}
+
+		actual := make([]int, 0)
+		bm.ForEachOne(func(i int) bool {
+			actual = append(actual, i)
+			return true
+		})
+		assert.Equal(t, expected, actual)
+
+		// Stopping early
+		if len(expected) > 2 {
+			actual = actual[:0]
+			bm.ForEachOne(func(i int) bool {
+				actual = append(actual, i)
+				return len(actual) < 2
+			})
+			assert.Equal(t, expected[:2], actual)
+		}
+	}
+}
+
+func TestCountOnes(t *testing.T) {
+	bm := New(128, 128)
+	bm.data = []uint64{
+		0x84d5f768e45d7022,
+		0x1feeb05a21aeb691,
+	}
+
+	for from := 0; from <= 128; from++ {
+		for to := from; to <= 128; to++ {
+			expected := 0
+			for i := from; i < to; i++ {
+				val, _ := bm.Get(i)
+				expected += int(val)
+			}
+
+			count, err := bm.CountOnes(from, to)
+			assert.Nil(t, err)
+			assert.Equal(t, expected, count, "CountOnes(%d, %d)", from, to)
+		}
+	}
+
+	_, err := bm.CountOnes(-1, 3)
+	assert.Error(t, err)
+
+	_, err = bm.CountOnes(0, 129)
+	assert.Error(t, err)
+
+	_, err = bm.CountOnes(5, 4)
+	assert.Error(t, err)
+}
+
 func TestString(t *testing.T) {
 	bm := New(256, 512)
 
@@ -225,6 +455,40 @@ func TestString(t *testing.T) {
 	assert.Equal(t, expected, str)
 }
 
+func TestMarshalBinary(t *testing.T) {
+	bm := New(100, 1024)
+	for _, bit := range []int{0, 17, 64, 127} {
+		assert.Nil(t, bm.Set(bit))
+	}
+
+	data, err := bm.MarshalBinary()
+	assert.Nil(t, err)
+	assert.Equal(t, 8+2*8, len(data))
+
+	decoded := &Bitmap{}
+	assert.Nil(t, decoded.UnmarshalBinary(data))
+	assert.True(t, bm.Equal(decoded))
+	assert.Equal(t, 128, decoded.Capacity)
+
+	// The append position is not encoded
+	assert.Equal(t, 100, bm.End())
+	assert.Equal(t, 128, decoded.End())
+}
+
+func TestUnmarshalBinaryInvalid(t *testing.T) {
+	tests := [][]byte{
+		{},
+		{0x00, 0x01},
+		// Length of 128 bits, but only one word of content
+		{0, 0, 0, 0, 0, 0, 0, 128, 0, 0, 0, 0, 0, 0, 0, 0},
+	}
+
+	for _, data := range tests {
+		bm := &Bitmap{}
+		assert.Error(t, bm.UnmarshalBinary(data))
+	}
+}
+
 func BenchmarkSet(b *testing.B) {
 	bm := New(0, b.N)
 
@@ -0,0 +1,305 @@
+package bitmap
+
+import (
+	"fmt"
+	"math/bits"
+	"sort"
+
+	"github.com/Lavode/surf/bitops/packed"
+)
+
+// EliasFano is a compressed encoding of a bitmap, storing the positions of its
+// 1-bits with Elias-Fano coding.
+//
+// Each position is split into its lower l bits, which are stored verbatim,
+// and its upper bits, which are stored in unary in a bitmap. With n 1-bits
+// among u bits, choosing l = floor(log2(u / n)) leads to a size of about
+// n * (2 + log2(u / n)) bits. For sparse bitmaps this is much less than u.
+//
+// The price to pay is speed. Rank and select queries need a select query on
+// the bitmap of upper bits, followed by a binary search among the positions
+// sharing the same upper bits. The bitmap of upper bits has a rank index, as built by
+// Bitmap.BuildRankIndex, such that the select query need not scan it from its
+// start.
+//
+// EliasFano implements RankSelect, and is read-only.
+type EliasFano struct {
+	// length is the number of bits of the encoded bitmap
+	length int
+	// ones is the number of 1-bits of the encoded bitmap
+	ones int
+	// lowWidth is the number of lower bits stored verbatim per position
+	lowWidth int
+	// low holds the lower bits of each position. It is nil if lowWidth is
+	// 0.
+	low *packed.Array
+	// high holds the upper bits of each position in unary. The i-th
+	// position, with upper bits h, is encoded as the 1-bit at index h + i.
+	// The 0-bits thus separate positions with differing upper bits.
+	high *Bitmap
+}
+
+// NewEliasFano encodes the content of bm.
+//
+// An error is returned if the 1-bits of bm cannot be enumerated.
+func NewEliasFano(bm RankSelect) (*EliasFano, error) {
+	ef := EliasFano{length: bm.Len()}
+
+	err := ForEachOne(bm, func(int) bool {
+		ef.ones++
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ef.lowWidth = eliasFanoLowWidth(ef.length, ef.ones)
+	if ef.lowWidth > 0 {
+		// The width is in [1, 63], so this cannot fail.
+		ef.low, _ = packed.New(ef.lowWidth, 0)
+	}
+
+	highLength := eliasFanoHighLength(ef.length, ef.ones, ef.lowWidth)
+	ef.high = New(0, highLength)
+
+	previousHigh := 0
+	err = ForEachOne(bm, func(bit int) bool {
+		high := bit >> ef.lowWidth
+
+		// One 0-bit per step in upper bits, followed by the 1-bit of
+		// this position.
+		ef.high.AppendZeros(high - previousHigh)
+		ef.high.AppendBit(1)
+		previousHigh = high
+
+		if ef.low != nil {
+			ef.low.Append(uint64(bit) & (1<<ef.lowWidth - 1))
+		}
+
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	ef.high.AppendZeros(highLength - ef.high.End())
+	ef.high.ShrinkToFit()
+	ef.high.BuildRankIndex()
+
+	return &ef, nil
+}
+
+// Len returns the number of bits of the encoded bitmap.
+func (ef *EliasFano) Len() int {
+	return ef.length
+}
+
+// Get retrieves the value at a given index.
+//
+// While the returned value is a byte, it will always be either 0 or 1.
+//
+// An error is returned if the index is invalid.
+func (ef *EliasFano) Get(bit int) (byte, error) {
+	if bit < 0 || bit >= ef.length {
+		return 0, fmt.Errorf("Invalid index %d. Must be in range [0, %d]", bit, ef.length-1)
+	}
+
+	// The position would be the one following all lesser positions.
+	before, err := ef.rank1(bit - 1)
+	if err != nil {
+		return 0, err
+	}
+
+	if before == ef.ones {
+		return 0, nil
+	}
+
+	next, err := ef.position(before)
+	if err != nil {
+		return 0, err
+	}
+
+	if next == bit {
+		return 1, nil
+	}
+
+	return 0, nil
+}
+
+// Rank returns the number of bits with value val, up to and including
+// position idx.
+//
+// An error is returned if the index is outside the range of the bitmap, or if
+// val is neither 0 nor 1.
+func (ef *EliasFano) Rank(val, idx int) (int, error) {
+	if idx < 0 || idx > ef.length-1 {
+		return 0, fmt.Errorf("Index must be in range [%d, %d]. Was %d]", 0, ef.length-1, idx)
+	}
+
+	if !(val == 0 || val == 1) {
+		return 0, fmt.Errorf("Val must be one of 0, 1. Was %d", val)
+	}
+
+	ones, err := ef.rank1(idx)
+	if err != nil {
+		return 0, err
+	}
+
+	if val == 1 {
+		return ones, nil
+	}
+	return idx + 1 - ones, nil
+}
+
+// Select returns the index of the nth bit of value val.
+//
+// Selecting 1-bits takes a single select query on the upper bits. Selecting
+// 0-bits is done by binary search over rank queries, so is considerably
+// slower.
+//
+// An error is returned if there is no nth bit of value val in the bitmap, or
+// if val is neither 0 nor 1.
+func (ef *EliasFano) Select(val, nth int) (int, error) {
+	if !(val == 0 || val == 1) {
+		return 0, fmt.Errorf("Val must be one of 0, 1. Was %d", val)
+	}
+
+	total := ef.ones
+	if val == 0 {
+		total = ef.length - ef.ones
+	}
+	if nth <= 0 || nth > total {
+		return 0, fmt.Errorf("Bitmap only contained %d bits of value %d, cannot select bit %d", total, val, nth)
+	}
+
+	if val == 1 {
+		return ef.position(nth - 1)
+	}
+
+	// Smallest index whose rank of 0-bits is nth
+	low, high := 0, ef.length-1
+	for low < high {
+		mid := low + (high-low)/2
+
+		zeros, err := ef.Rank(0, mid)
+		if err != nil {
+			return 0, err
+		}
+
+		if zeros < nth {
+			low = mid + 1
+		} else {
+			high = mid
+		}
+	}
+
+	return low, nil
+}
+
+// ForEachOne calls f with the index of each 1-bit, in increasing order.
+//
+// Rather than selecting each position on its own, it decodes them in a single
+// pass over the upper bits. Iteration stops early if f returns false.
+func (ef *EliasFano) ForEachOne(f func(i int) bool) {
+	i := 0
+	ef.high.ForEachOne(func(highIdx int) bool {
+		position := (highIdx-i)<<ef.lowWidth | ef.lowBits(i)
+		i++
+
+		return f(position)
+	})
+}
+
+// position returns the position of the i-th 1-bit, counting from 0.
+func (ef *EliasFano) position(i int) (int, error) {
+	highIdx, err := ef.high.Select(1, i+1)
+	if err != nil {
+		return 0, err
+	}
+
+	return (highIdx-i)<<ef.lowWidth | ef.lowBits(i), nil
+}
+
+// lowBits returns the lower bits of the i-th position.
+func (ef *EliasFano) lowBits(i int) int {
+	if ef.low == nil {
+		return 0
+	}
+
+	low, _ := ef.low.Get(i)
+	return int(low)
+}
+
+// rank1 returns the number of 1-bits up to and including position idx, which
+// may be -1.
+func (ef *EliasFano) rank1(idx int) (int, error) {
+	if idx < 0 || ef.ones == 0 {
+		return 0, nil
+	}
+
+	high := idx >> ef.lowWidth
+	low := idx & (1<<ef.lowWidth - 1)
+
+	// Positions with upper bits h start after the h-th 0-bit.
+	start := 0
+	if high > 0 {
+		zero, err := ef.high.Select(0, high)
+		if err != nil {
+			return 0, err
+		}
+		start = zero + 1
+	}
+
+	// All positions before start have lesser upper bits. Of those sharing
+	// our upper bits, which are sorted by their lower bits, we count the
+	// ones not exceeding ours by binary search.
+	end, found := ef.high.NextZero(start)
+	if !found {
+		end = ef.high.Len()
+	}
+
+	first := start - high
+	rank := first + sort.Search(end-start, func(i int) bool {
+		return ef.lowBits(first+i) > low
+	})
+
+	return rank, nil
+}
+
+// SizeBits returns the number of bits used by the encoding.
+func (ef *EliasFano) SizeBits() int {
+	size := ef.high.Len()
+	if ef.low != nil {
+		size += ef.ones * ef.lowWidth
+	}
+
+	return size
+}
+
+// EliasFanoSizeBits returns the number of bits the Elias-Fano encoding of a
+// bitmap of length bits, ones of which are set, uses, as reported by
+// SizeBits. It allows to plan for the size of an encoding without building
+// it.
+func EliasFanoSizeBits(length, ones int) int {
+	lowWidth := eliasFanoLowWidth(length, ones)
+	highLength := eliasFanoHighLength(length, ones, lowWidth)
+
+	// The bitmap of upper bits is stored in uint64s
+	return 64*((highLength+63)/64) + ones*lowWidth
+}
+
+// eliasFanoLowWidth returns the number of lower bits stored verbatim per
+// position, for a bitmap of length bits, ones of which are set.
+func eliasFanoLowWidth(length, ones int) int {
+	if ones == 0 || length <= ones {
+		return 0
+	}
+
+	return bits.Len(uint(length/ones)) - 1
+}
+
+// eliasFanoHighLength returns the number of bits of the bitmap of upper bits,
+// for a bitmap of length bits, ones of which are set, with lowWidth lower bits
+// stored verbatim.
+func eliasFanoHighLength(length, ones, lowWidth int) int {
+	return ones + length>>lowWidth + 1
+}
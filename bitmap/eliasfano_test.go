@@ -0,0 +1,177 @@
+package bitmap
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// checkRankSelect compares all queries of rs against those of the plain
+// bitmap bm.
+func checkRankSelect(t *testing.T, bm *Bitmap, rs RankSelect) {
+	assert.Equal(t, bm.Len(), rs.Len())
+
+	ones := bm.PopCount()
+	for i := 0; i < bm.Len(); i++ {
+		expected, _ := bm.Get(i)
+		actual, err := rs.Get(i)
+		assert.Nil(t, err)
+		assert.Equal(t, expected, actual, "Get(%d)", i)
+
+		for val := 0; val <= 1; val++ {
+			expectedRank, _ := bm.Rank(val, i)
+			actualRank, err := rs.Rank(val, i)
+			assert.Nil(t, err)
+			assert.Equal(t, expectedRank, actualRank, "Rank(%d, %d)", val, i)
+		}
+
+		expectedNext, expectedFound := bm.NextOne(i)
+		actualNext, actualFound, err := NextOne(rs, i)
+		assert.Nil(t, err)
+		assert.Equal(t, expectedFound, actualFound, "NextOne(%d)", i)
+		assert.Equal(t, expectedNext, actualNext, "NextOne(%d)", i)
+	}
+
+	for nth := 1; nth <= ones; nth++ {
+		expected, _ := bm.Select(1, nth)
+		actual, err := rs.Select(1, nth)
+		assert.Nil(t, err)
+		assert.Equal(t, expected, actual, "Select(1, %d)", nth)
+	}
+
+	for nth := 1; nth <= bm.Len()-ones; nth++ {
+		expected, _ := bm.Select(0, nth)
+		actual, err := rs.Select(0, nth)
+		assert.Nil(t, err)
+		assert.Equal(t, expected, actual, "Select(0, %d)", nth)
+	}
+
+	for from := 0; from <= bm.Len(); from += 17 {
+		for to := from; to <= bm.Len(); to += 23 {
+			expected, _ := bm.CountOnes(from, to)
+			actual, err := CountOnes(rs, from, to)
+			assert.Nil(t, err)
+			assert.Equal(t, expected, actual, "CountOnes(%d, %d)", from, to)
+		}
+	}
+
+	var expectedOnes, actualOnes []int
+	bm.ForEachOne(func(i int) bool {
+		expectedOnes = append(expectedOnes, i)
+		return true
+	})
+	assert.Nil(t, ForEachOne(rs, func(i int) bool {
+		actualOnes = append(actualOnes, i)
+		return true
+	}))
+	assert.Equal(t, expectedOnes, actualOnes)
+
+	decoded, err := ToBitmap(rs)
+	assert.Nil(t, err)
+	assert.True(t, bm.Equal(decoded))
+}
+
+// newEliasFano encodes bm, failing the test on error.
+func newEliasFano(t testing.TB, bm *Bitmap) *EliasFano {
+	ef, err := NewEliasFano(bm)
+	assert.Nil(t, err)
+
+	// Select queries on the upper bits rely on their rank index.
+	assert.True(t, ef.high.HasRankIndex())
+
+	return ef
+}
+
+func TestEliasFano(t *testing.T) {
+	for _, bm := range randomBitmaps() {
+		checkRankSelect(t, bm, newEliasFano(t, bm))
+	}
+
+	// Clustered 1-bits, sharing upper bits
+	bm := New(1024, 1024)
+	for _, bit := range []int{0, 1, 2, 3, 500, 501, 502, 1020, 1023} {
+		assert.Nil(t, bm.Set(bit))
+	}
+	checkRankSelect(t, bm, newEliasFano(t, bm))
+}
+
+func TestEliasFanoInvalidArguments(t *testing.T) {
+	bm := New(128, 128)
+	assert.Nil(t, bm.Set(17))
+	ef := newEliasFano(t, bm)
+
+	_, err := ef.Get(-1)
+	assert.Error(t, err)
+	_, err = ef.Get(128)
+	assert.Error(t, err)
+
+	_, err = ef.Rank(2, 3)
+	assert.Error(t, err)
+	_, err = ef.Rank(1, 128)
+	assert.Error(t, err)
+
+	_, err = ef.Select(2, 1)
+	assert.Error(t, err)
+	_, err = ef.Select(1, 0)
+	assert.Error(t, err)
+	_, err = ef.Select(1, 2)
+	assert.Error(t, err)
+	_, err = ef.Select(0, 128)
+	assert.Error(t, err)
+}
+
+func TestEliasFanoSize(t *testing.T) {
+	bm := New(1<<16, 1<<16)
+	for bit := 0; bit < bm.Len(); bit += 1000 {
+		assert.Nil(t, bm.Set(bit))
+	}
+
+	ef := newEliasFano(t, bm)
+	// 66 positions, with 9 lower bits each, and 66 + 2^16 / 2^9 + 1 upper
+	// bits, padded to a multiple of 64.
+	assert.Equal(t, 66*9+256, ef.SizeBits())
+	assert.Less(t, ef.SizeBits(), bm.Len()/50)
+	assert.Equal(t, ef.SizeBits(), EliasFanoSizeBits(bm.Len(), 66))
+
+	// The size is known without encoding, for any density
+	rng := rand.New(rand.NewSource(42))
+	for _, length := range []int{0, 64, 256, 1000, 4096} {
+		for _, every := range []int{1, 2, 7, 100, 5000} {
+			bm := New(length, length)
+			ones := 0
+			for bit := rng.Intn(every); bit < length; bit += 1 + rng.Intn(every) {
+				assert.Nil(t, bm.Set(bit))
+				ones++
+			}
+
+			ef := newEliasFano(t, bm)
+			assert.Equal(t, ef.SizeBits(), EliasFanoSizeBits(bm.Len(), ones), "%d of %d bits", ones, length)
+		}
+	}
+}
+
+func BenchmarkRankPlain(b *testing.B) {
+	bm := New(1<<16, 1<<16)
+	for bit := 0; bit < bm.Len(); bit += 100 {
+		bm.Set(bit)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		bm.Rank(1, i%bm.Len())
+	}
+}
+
+func BenchmarkRankEliasFano(b *testing.B) {
+	bm := New(1<<16, 1<<16)
+	for bit := 0; bit < bm.Len(); bit += 100 {
+		bm.Set(bit)
+	}
+	ef := newEliasFano(b, bm)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ef.Rank(1, i%bm.Len())
+	}
+}
@@ -0,0 +1,100 @@
+package bitmap
+
+import (
+	"math/bits"
+
+	"github.com/Lavode/surf/bitops"
+)
+
+// rankSampleWords is the number of uint64s covered by each entry of a rank
+// index.
+const rankSampleWords = 8
+
+// BuildRankIndex builds an index of the number of 1-bits preceding every
+// rankSampleWords-th uint64 of the bitmap.
+//
+// With the index in place, Rank, Select and CountOnes only count the bits of
+// at most rankSampleWords uint64s, rather than scanning the bitmap from its
+// start. It costs one int per 512 bits.
+//
+// The index is built explicitly, rather than on first use, such that a
+// bitmap which is no longer modified may be queried concurrently. Modifying
+// the bitmap discards the index, so it has to be rebuilt afterwards.
+func (bm *Bitmap) BuildRankIndex() {
+	words := bm.length / 64
+	ranks := make([]int, (words+rankSampleWords-1)/rankSampleWords)
+
+	cnt := 0
+	for i, word := range bm.data[:words] {
+		if i%rankSampleWords == 0 {
+			ranks[i/rankSampleWords] = cnt
+		}
+		cnt += bits.OnesCount64(word)
+	}
+
+	bm.ranks = ranks
+}
+
+// HasRankIndex returns whether the bitmap has a rank index, as built by
+// BuildRankIndex and not discarded by a modification since.
+func (bm *Bitmap) HasRankIndex() bool {
+	return bm.ranks != nil
+}
+
+// dropRankIndex discards the rank index, which must be done whenever the
+// bitmap is modified.
+func (bm *Bitmap) dropRankIndex() {
+	bm.ranks = nil
+}
+
+// indexedOnesBefore returns the number of 1-bits preceding position pos,
+// which must be in [0, Len()], using the rank index.
+func (bm *Bitmap) indexedOnesBefore(pos int) int {
+	if pos == 0 {
+		return 0
+	}
+
+	idx := pos / 64
+	sample := idx / rankSampleWords
+	if sample == len(bm.ranks) {
+		// pos is the bitmap's length, and the last sample is full.
+		sample--
+	}
+
+	cnt := bm.ranks[sample]
+	for i := sample * rankSampleWords; i < idx; i++ {
+		cnt += bits.OnesCount64(bm.data[i])
+	}
+	if offset := pos % 64; offset > 0 {
+		cnt += bitops.Rank64(bm.data[idx], offset-1)
+	}
+
+	return cnt
+}
+
+// indexedSelectStart returns the index of the first uint64 of the last
+// sample preceded by fewer than nth bits of value val, along with the number
+// of such bits preceding it, using the rank index.
+//
+// Select continues scanning from there, which takes at most rankSampleWords
+// uint64s if the bitmap holds the bit.
+func (bm *Bitmap) indexedSelectStart(val, nth int) (int, int) {
+	before := func(sample int) int {
+		if val == 1 {
+			return bm.ranks[sample]
+		}
+		return 64*rankSampleWords*sample - bm.ranks[sample]
+	}
+
+	low, high := 0, len(bm.ranks)-1
+	for low < high {
+		mid := low + (high-low+1)/2
+		if before(mid) < nth {
+			low = mid
+		} else {
+			high = mid - 1
+		}
+	}
+
+	return low * rankSampleWords, before(low)
+}
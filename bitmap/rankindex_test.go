@@ -0,0 +1,77 @@
+package bitmap
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRankIndex(t *testing.T) {
+	rng := rand.New(rand.NewSource(42))
+
+	// Sizes around multiples of the sample size, including a full last
+	// sample.
+	for _, size := range []int{64, 500, 512, 1024, 1600, 4096} {
+		indexed, _ := randomBits(rng, size, size)
+		plain := indexed.clone(size)
+		indexed.BuildRankIndex()
+		assert.True(t, indexed.HasRankIndex())
+		assert.False(t, plain.HasRankIndex())
+
+		for i := 0; i < indexed.Len(); i++ {
+			for val := 0; val <= 1; val++ {
+				expected, _ := plain.Rank(val, i)
+				actual, err := indexed.Rank(val, i)
+				assert.Nil(t, err)
+				assert.Equal(t, expected, actual, "Rank(%d, %d) of %d bits", val, i, size)
+			}
+		}
+
+		for val := 0; val <= 1; val++ {
+			for nth := 1; ; nth++ {
+				expected, expectedErr := plain.Select(val, nth)
+				actual, err := indexed.Select(val, nth)
+				if expectedErr != nil {
+					assert.NotNil(t, err, "Select(%d, %d) of %d bits", val, nth, size)
+					break
+				}
+
+				assert.Nil(t, err)
+				assert.Equal(t, expected, actual, "Select(%d, %d) of %d bits", val, nth, size)
+			}
+		}
+
+		for _, r := range [][2]int{{0, 0}, {0, size}, {3, size - 5}, {0, 513}, {600, size}, {size, size}} {
+			if r[0] > r[1] || r[1] > indexed.Len() {
+				continue
+			}
+
+			expected, _ := plain.CountOnes(r[0], r[1])
+			actual, err := indexed.CountOnes(r[0], r[1])
+			assert.Nil(t, err)
+			assert.Equal(t, expected, actual, "CountOnes(%d, %d) of %d bits", r[0], r[1], size)
+		}
+	}
+}
+
+func TestRankIndexDroppedOnModification(t *testing.T) {
+	modifications := map[string]func(bm *Bitmap){
+		"Set":        func(bm *Bitmap) { bm.Set(3) },
+		"Unset":      func(bm *Bitmap) { bm.Unset(3) },
+		"AppendBit":  func(bm *Bitmap) { bm.AppendBit(1) },
+		"CopyRange":  func(bm *Bitmap) { CopyRange(bm, 0, bm, 64, 64) },
+		"OrInPlace":  func(bm *Bitmap) { bm.OrInPlace(New(128, 128)) },
+		"NotInPlace": func(bm *Bitmap) { bm.NotInPlace() },
+		"Grow":       func(bm *Bitmap) { bm.Get(1000) },
+	}
+
+	for name, modify := range modifications {
+		bm := New(512, 1024)
+		bm.end = 0
+		bm.BuildRankIndex()
+
+		modify(bm)
+		assert.False(t, bm.HasRankIndex(), "Rank index kept after %s", name)
+	}
+}
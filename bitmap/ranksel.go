@@ -0,0 +1,141 @@
+package bitmap
+
+import "fmt"
+
+// RankSelect is a read-only bitmap supporting rank and select queries.
+//
+// It is implemented by Bitmap, as well as by compressed encodings such as
+// EliasFano, allowing to pick a space/time trade-off per bitmap. Semantics of
+// the methods are those of the corresponding methods of Bitmap.
+type RankSelect interface {
+	// Get retrieves the value at a given index.
+	Get(bit int) (byte, error)
+	// Rank returns the number of bits with value val, up to and including
+	// position idx.
+	Rank(val, idx int) (int, error)
+	// Select returns the index of the nth bit of value val.
+	Select(val, nth int) (int, error)
+	// Len returns the number of bits in the bitmap.
+	Len() int
+}
+
+// NextOne returns the index of the first 1-bit of rs at or after position
+// from, as Bitmap.NextOne does.
+//
+// Implementations providing a NextOne method of their own, such as Bitmap,
+// are queried directly. Others are answered with rank and select queries, any
+// error of which is returned, rather than taken to mean there is no such bit.
+func NextOne(rs RankSelect, from int) (int, bool, error) {
+	if scanner, ok := rs.(interface{ NextOne(int) (int, bool) }); ok {
+		next, found := scanner.NextOne(from)
+		return next, found, nil
+	}
+
+	if from < 0 {
+		from = 0
+	}
+	if from >= rs.Len() {
+		return 0, false, nil
+	}
+
+	before, err := CountOnes(rs, 0, from)
+	if err != nil {
+		return 0, false, err
+	}
+
+	total, err := CountOnes(rs, 0, rs.Len())
+	if err != nil {
+		return 0, false, err
+	}
+	if before == total {
+		return 0, false, nil
+	}
+
+	next, err := rs.Select(1, before+1)
+	if err != nil {
+		return 0, false, err
+	}
+
+	return next, true, nil
+}
+
+// ForEachOne calls f with the index of each 1-bit of rs, in increasing order,
+// as Bitmap.ForEachOne does. Iteration stops early if f returns false.
+//
+// Implementations providing a ForEachOne method of their own, such as Bitmap
+// and EliasFano, are walked directly. Others are walked with NextOne, any
+// error of which is returned.
+func ForEachOne(rs RankSelect, f func(i int) bool) error {
+	if walker, ok := rs.(interface{ ForEachOne(func(int) bool) }); ok {
+		walker.ForEachOne(f)
+		return nil
+	}
+
+	for from := 0; ; {
+		bit, found, err := NextOne(rs, from)
+		if err != nil {
+			return err
+		}
+		if !found || !f(bit) {
+			return nil
+		}
+
+		from = bit + 1
+	}
+}
+
+// CountOnes returns the number of 1-bits of rs in the range [from, to), as
+// Bitmap.CountOnes does.
+//
+// Implementations providing a CountOnes method of their own, such as Bitmap,
+// are queried directly. Others are answered with up to two rank queries.
+func CountOnes(rs RankSelect, from, to int) (int, error) {
+	if counter, ok := rs.(interface{ CountOnes(int, int) (int, error) }); ok {
+		return counter.CountOnes(from, to)
+	}
+
+	if from < 0 || to > rs.Len() || from > to {
+		return 0, fmt.Errorf("Range [%d, %d) must be within [0, %d)", from, to, rs.Len())
+	}
+
+	if from == to {
+		return 0, nil
+	}
+
+	cnt, err := rs.Rank(1, to-1)
+	if err != nil {
+		return 0, err
+	}
+
+	if from > 0 {
+		before, err := rs.Rank(1, from-1)
+		if err != nil {
+			return 0, err
+		}
+		cnt -= before
+	}
+
+	return cnt, nil
+}
+
+// ToBitmap returns the content of rs as a Bitmap of the same length.
+//
+// If rs is a Bitmap, it is returned as is. Otherwise its 1-bits are walked
+// with ForEachOne, any error of which is returned.
+func ToBitmap(rs RankSelect) (*Bitmap, error) {
+	if bm, ok := rs.(*Bitmap); ok {
+		return bm, nil
+	}
+
+	bm := New(rs.Len(), rs.Len())
+	err := ForEachOne(rs, func(bit int) bool {
+		// The bitmap is as long as rs, so this cannot fail.
+		bm.Set(bit)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return bm, nil
+}
@@ -0,0 +1,53 @@
+package bitmap
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// rankSelectOnly hides all methods of a RankSelect other than those of the
+// interface, such that the helpers fall back to rank and select queries.
+type rankSelectOnly struct {
+	RankSelect
+}
+
+// failingRankSelect is a RankSelect whose rank and select queries fail.
+type failingRankSelect struct {
+	length int
+}
+
+var errFailing = errors.New("Failing bitmap")
+
+func (rs failingRankSelect) Get(bit int) (byte, error)        { return 0, errFailing }
+func (rs failingRankSelect) Rank(val, idx int) (int, error)   { return 0, errFailing }
+func (rs failingRankSelect) Select(val, nth int) (int, error) { return 0, errFailing }
+func (rs failingRankSelect) Len() int                         { return rs.length }
+
+func TestRankSelectFallbacks(t *testing.T) {
+	for _, bm := range randomBitmaps() {
+		checkRankSelect(t, bm, rankSelectOnly{bm})
+	}
+}
+
+func TestRankSelectFallbackErrors(t *testing.T) {
+	rs := failingRankSelect{length: 128}
+
+	_, found, err := NextOne(rs, 3)
+	assert.ErrorIs(t, err, errFailing)
+	assert.False(t, found)
+
+	assert.ErrorIs(t, ForEachOne(rs, func(int) bool { return true }), errFailing)
+
+	_, err = ToBitmap(rs)
+	assert.ErrorIs(t, err, errFailing)
+
+	_, err = NewEliasFano(rs)
+	assert.ErrorIs(t, err, errFailing)
+
+	// Past the end, there is nothing to query.
+	_, found, err = NextOne(rs, 128)
+	assert.Nil(t, err)
+	assert.False(t, found)
+}
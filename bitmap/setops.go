@@ -0,0 +1,243 @@
+package bitmap
+
+import (
+	"fmt"
+	"math/bits"
+
+	"github.com/Lavode/surf/bitops"
+)
+
+// setOp is a bitwise operation combining two bitmaps.
+type setOp int
+
+const (
+	opAnd setOp = iota
+	opOr
+	opXor
+	opAndNot
+)
+
+// And returns a new bitmap holding the bits set in both bm and other.
+//
+// Bitmaps of different lengths are combined as if the shorter one was padded
+// with 0-bits. The result has the greater of the two lengths and capacities.
+func (bm *Bitmap) And(other *Bitmap) *Bitmap {
+	return bm.combined(other, opAnd)
+}
+
+// Or returns a new bitmap holding the bits set in either of bm and other.
+//
+// Lengths and capacities are handled as by And.
+func (bm *Bitmap) Or(other *Bitmap) *Bitmap {
+	return bm.combined(other, opOr)
+}
+
+// Xor returns a new bitmap holding the bits set in exactly one of bm and
+// other.
+//
+// Lengths and capacities are handled as by And.
+func (bm *Bitmap) Xor(other *Bitmap) *Bitmap {
+	return bm.combined(other, opXor)
+}
+
+// AndNot returns a new bitmap holding the bits set in bm, but not in other.
+//
+// Lengths and capacities are handled as by And.
+func (bm *Bitmap) AndNot(other *Bitmap) *Bitmap {
+	return bm.combined(other, opAndNot)
+}
+
+// Not returns a new bitmap of the same length and capacity, with all bits up
+// to its length inverted.
+//
+// Bits past the length, which a lazily grown bitmap has not allocated yet, are
+// not inverted, such that inverting does not grow the bitmap to its capacity.
+// Bits past the capacity, which exist as the bitmap is stored in uint64s,
+// remain 0.
+func (bm *Bitmap) Not() *Bitmap {
+	out := bm.clone(bm.Capacity)
+	out.NotInPlace()
+
+	return out
+}
+
+// AndInPlace unsets all bits of bm which are not set in other.
+func (bm *Bitmap) AndInPlace(other *Bitmap) {
+	bm.combine(other, opAnd)
+}
+
+// OrInPlace sets all bits of bm which are set in other.
+//
+// An error is returned, and bm left unchanged, if other has a bit set which
+// lies beyond bm's capacity.
+func (bm *Bitmap) OrInPlace(other *Bitmap) error {
+	err := bm.checkFits(other)
+	if err != nil {
+		return err
+	}
+
+	bm.combine(other, opOr)
+	return nil
+}
+
+// XorInPlace inverts all bits of bm which are set in other.
+//
+// An error is returned, and bm left unchanged, if other has a bit set which
+// lies beyond bm's capacity.
+func (bm *Bitmap) XorInPlace(other *Bitmap) error {
+	err := bm.checkFits(other)
+	if err != nil {
+		return err
+	}
+
+	bm.combine(other, opXor)
+	return nil
+}
+
+// AndNotInPlace unsets all bits of bm which are set in other.
+func (bm *Bitmap) AndNotInPlace(other *Bitmap) {
+	bm.combine(other, opAndNot)
+}
+
+// NotInPlace inverts all bits of bm up to its length, as Not does.
+//
+// Bits past the capacity, which exist as the bitmap is stored in uint64s,
+// remain 0.
+func (bm *Bitmap) NotInPlace() {
+	bm.dropRankIndex()
+
+	for i := range bm.data[:bm.length/64] {
+		bm.data[i] = ^bm.data[i]
+	}
+
+	bm.clearPastCapacity()
+}
+
+// PopCount returns the number of 1-bits in the bitmap.
+func (bm *Bitmap) PopCount() int {
+	cnt := 0
+	for _, word := range bm.data[:bm.length/64] {
+		cnt += bits.OnesCount64(word)
+	}
+
+	return cnt
+}
+
+// Intersects checks whether bm and other have any 1-bit in common.
+//
+// Unlike checking the result of And, it does not allocate and stops at the
+// first common bit.
+func (bm *Bitmap) Intersects(other *Bitmap) bool {
+	n := bm.length / 64
+	if other.length/64 < n {
+		n = other.length / 64
+	}
+
+	for i := 0; i < n; i++ {
+		if bm.data[i]&other.data[i] != 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// combined returns a new bitmap holding the result of combining bm and other
+// with op.
+func (bm *Bitmap) combined(other *Bitmap, op setOp) *Bitmap {
+	capacity := bm.Capacity
+	if other.Capacity > capacity {
+		capacity = other.Capacity
+	}
+
+	out := bm.clone(capacity)
+	out.resize(other.length)
+	out.combine(other, op)
+	if other.end > out.end {
+		out.end = other.end
+	}
+
+	return out
+}
+
+// combine combines bm with other using op, storing the result in bm.
+//
+// If op may set bits past bm's length, bm is first grown up to the length of
+// other, limited by bm's capacity. It is up to the caller to ensure that
+// other has no bits set past the capacity, where this matters.
+func (bm *Bitmap) combine(other *Bitmap, op setOp) {
+	if (op == opOr || op == opXor) && other.length > bm.length {
+		bm.resize(other.length)
+	}
+
+	dst := bm.data[:bm.length/64]
+	src := other.data[:other.length/64]
+
+	// Words of dst past the end of src are combined with 0s, which only
+	// affects them for And.
+	if len(src) < len(dst) {
+		if op == opAnd {
+			for i := len(src); i < len(dst); i++ {
+				dst[i] = 0
+			}
+		}
+		dst = dst[:len(src)]
+	}
+
+	// Words of src past the end of dst are only there if they lie beyond
+	// bm's capacity, so are ignored.
+	src = src[:len(dst)]
+
+	switch op {
+	case opAnd:
+		for i := range dst {
+			dst[i] &= src[i]
+		}
+	case opOr:
+		for i := range dst {
+			dst[i] |= src[i]
+		}
+	case opXor:
+		for i := range dst {
+			dst[i] ^= src[i]
+		}
+	case opAndNot:
+		for i := range dst {
+			dst[i] &^= src[i]
+		}
+	}
+
+	bm.clearPastCapacity()
+	bm.dropRankIndex()
+}
+
+// checkFits returns an error if other has a 1-bit past bm's capacity.
+func (bm *Bitmap) checkFits(other *Bitmap) error {
+	bit, found := other.NextOne(bm.Capacity)
+	if found {
+		return fmt.Errorf("Bit %d is set, but bitmap only has capacity for %d bits", bit, bm.Capacity)
+	}
+
+	return nil
+}
+
+// clone returns a copy of bm with the given capacity, which must not be less
+// than bm's.
+func (bm *Bitmap) clone(capacity int) *Bitmap {
+	out := New(bm.length, capacity)
+	copy(out.data, bm.data[:bm.length/64])
+	out.end = bm.end
+
+	return out
+}
+
+// clearPastCapacity unsets the bits past the capacity, but within the last
+// uint64 of the bitmap.
+func (bm *Bitmap) clearPastCapacity() {
+	if bm.length <= bm.Capacity {
+		return
+	}
+
+	last := bm.length/64 - 1
+	bm.data[last] &= bitops.LeadingOnesMask(bm.Capacity - 64*last)
+}
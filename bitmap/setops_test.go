@@ -0,0 +1,249 @@
+package bitmap
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// bitAt returns the bit at index i, treating bits past the bitmap's length as
+// 0. Unlike Get, it never resizes the bitmap.
+func bitAt(bm *Bitmap, i int) byte {
+	if i >= bm.length {
+		return 0
+	}
+
+	val, _ := bm.Get(i)
+	return val
+}
+
+// setOpBitmaps returns pairs of bitmaps of differing lengths and capacities.
+func setOpBitmaps() [][2]*Bitmap {
+	rng := rand.New(rand.NewSource(42))
+
+	random := func(size, capacity int) *Bitmap {
+		bm := New(size, capacity)
+		for i := 0; i < size; i++ {
+			if rng.Intn(2) == 1 {
+				bm.Set(i)
+			}
+		}
+
+		return bm
+	}
+
+	sizes := []struct{ size, capacity int }{
+		{0, 0},
+		{64, 64},
+		{100, 100},
+		{128, 300},
+		{256, 256},
+	}
+
+	pairs := make([][2]*Bitmap, 0)
+	for _, a := range sizes {
+		for _, b := range sizes {
+			pairs = append(pairs, [2]*Bitmap{random(a.size, a.capacity), random(b.size, b.capacity)})
+		}
+	}
+
+	return pairs
+}
+
+func TestSetOps(t *testing.T) {
+	ops := []struct {
+		name      string
+		op        func(a, b *Bitmap) *Bitmap
+		reference func(a, b byte) byte
+	}{
+		{"And", (*Bitmap).And, func(a, b byte) byte { return a & b }},
+		{"Or", (*Bitmap).Or, func(a, b byte) byte { return a | b }},
+		{"Xor", (*Bitmap).Xor, func(a, b byte) byte { return a ^ b }},
+		{"AndNot", (*Bitmap).AndNot, func(a, b byte) byte { return a &^ b }},
+	}
+
+	for _, pair := range setOpBitmaps() {
+		a, b := pair[0], pair[1]
+
+		for _, op := range ops {
+			aBefore := a.clone(a.Capacity)
+			bBefore := b.clone(b.Capacity)
+
+			out := op.op(a, b)
+			assert.True(t, a.Equal(aBefore), "%s modified its receiver", op.name)
+			assert.True(t, b.Equal(bBefore), "%s modified its argument", op.name)
+
+			assert.Equal(t, maxInt(a.length, b.length), out.length, op.name)
+			assert.Equal(t, maxInt(a.Capacity, b.Capacity), out.Capacity, op.name)
+
+			for i := 0; i < out.length; i++ {
+				expected := op.reference(bitAt(a, i), bitAt(b, i))
+				assert.Equal(t, expected, bitAt(out, i), "%s of bit %d", op.name, i)
+			}
+		}
+	}
+}
+
+func TestSetOpsInPlace(t *testing.T) {
+	ops := []struct {
+		name string
+		op   func(a, b *Bitmap) error
+		out  func(a, b *Bitmap) *Bitmap
+	}{
+		{"AndInPlace", func(a, b *Bitmap) error { a.AndInPlace(b); return nil }, (*Bitmap).And},
+		{"OrInPlace", (*Bitmap).OrInPlace, (*Bitmap).Or},
+		{"XorInPlace", (*Bitmap).XorInPlace, (*Bitmap).Xor},
+		{"AndNotInPlace", func(a, b *Bitmap) error { a.AndNotInPlace(b); return nil }, (*Bitmap).AndNot},
+	}
+
+	for _, pair := range setOpBitmaps() {
+		a, b := pair[0], pair[1]
+
+		for _, op := range ops {
+			expected := op.out(a, b)
+
+			inPlace := a.clone(a.Capacity)
+			err := op.op(inPlace, b)
+
+			// Bits of b which do not fit into a cannot be set.
+			_, fits := b.NextOne(a.Capacity)
+			if fits && (op.name == "OrInPlace" || op.name == "XorInPlace") {
+				assert.Error(t, err, op.name)
+				assert.True(t, inPlace.Equal(a), "%s modified bitmap despite error", op.name)
+				continue
+			}
+
+			assert.Nil(t, err, op.name)
+			assert.Equal(t, a.Capacity, inPlace.Capacity, op.name)
+			for i := 0; i < expected.length; i++ {
+				assert.Equal(t, bitAt(expected, i), bitAt(inPlace, i), "%s of bit %d", op.name, i)
+			}
+		}
+	}
+}
+
+func TestNot(t *testing.T) {
+	for _, pair := range setOpBitmaps() {
+		bm := pair[0]
+
+		out := bm.Not()
+		assert.Equal(t, bm.length, out.length)
+		assert.Equal(t, bm.Capacity, out.Capacity)
+
+		for i := 0; i < out.length; i++ {
+			expected := 1 - bitAt(bm, i)
+			if i >= bm.Capacity {
+				expected = 0
+			}
+			assert.Equal(t, expected, bitAt(out, i), "Not of bit %d", i)
+		}
+
+		inPlace := bm.clone(bm.Capacity)
+		inPlace.NotInPlace()
+		assert.True(t, out.Equal(inPlace))
+
+		// Inverting twice yields the original
+		inPlace.NotInPlace()
+		assert.True(t, bm.Equal(inPlace))
+	}
+
+	// Lazily grown bitmaps are not grown to their capacity
+	bm := New(0, 1<<30)
+	assert.Nil(t, bm.Set(3))
+
+	out := bm.Not()
+	assert.Equal(t, 64, out.Len())
+	assert.Equal(t, 63, out.PopCount())
+}
+
+func TestPopCountAndIntersects(t *testing.T) {
+	for _, pair := range setOpBitmaps() {
+		a, b := pair[0], pair[1]
+
+		ones := 0
+		intersects := false
+		for i := 0; i < a.length; i++ {
+			ones += int(bitAt(a, i))
+			intersects = intersects || bitAt(a, i)&bitAt(b, i) == 1
+		}
+
+		assert.Equal(t, ones, a.PopCount())
+		assert.Equal(t, intersects, a.Intersects(b))
+		assert.Equal(t, intersects, b.Intersects(a))
+		assert.Equal(t, intersects, a.And(b).PopCount() > 0)
+	}
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func benchmarkBitmaps(n int) (*Bitmap, *Bitmap) {
+	rng := rand.New(rand.NewSource(42))
+
+	a := New(n, n)
+	b := New(n, n)
+	for i := range a.data {
+		a.data[i] = rng.Uint64()
+		b.data[i] = rng.Uint64()
+	}
+
+	return a, b
+}
+
+func BenchmarkAnd(b *testing.B) {
+	x, y := benchmarkBitmaps(1 << 20)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		x.And(y)
+	}
+}
+
+func BenchmarkAndInPlace(b *testing.B) {
+	x, y := benchmarkBitmaps(1 << 20)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		x.AndInPlace(y)
+	}
+}
+
+// BenchmarkAndBitwise is the baseline of BenchmarkAndInPlace, combining
+// bitmaps one bit at a time.
+func BenchmarkAndBitwise(b *testing.B) {
+	x, y := benchmarkBitmaps(1 << 20)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for bit := 0; bit < x.length; bit++ {
+			val, _ := y.Get(bit)
+			if val == 0 {
+				x.Unset(bit)
+			}
+		}
+	}
+}
+
+func BenchmarkPopCount(b *testing.B) {
+	x, _ := benchmarkBitmaps(1 << 20)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		x.PopCount()
+	}
+}
+
+func BenchmarkIntersects(b *testing.B) {
+	x, y := benchmarkBitmaps(1 << 20)
+	y.AndNotInPlace(x)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		x.Intersects(y)
+	}
+}
@@ -0,0 +1,142 @@
+package bitmap
+
+import (
+	"fmt"
+
+	"github.com/Lavode/surf/bitops"
+)
+
+// Slice returns a new bitmap holding the bits of bm in the range [from, to).
+//
+// The new bitmap's capacity, as well as its End, is the number of bits in the
+// range.
+//
+// An error is returned if the range is not within the bitmap's length.
+func (bm *Bitmap) Slice(from, to int) (*Bitmap, error) {
+	if from < 0 || to < from || to > bm.length {
+		return nil, fmt.Errorf("Range [%d, %d) must be within [0, %d)", from, to, bm.length)
+	}
+
+	out := New(to-from, to-from)
+	// The range was checked above, so this cannot fail.
+	CopyRange(out, 0, bm, from, to-from)
+
+	return out, nil
+}
+
+// Concat returns a new bitmap holding the bits of each of bitmaps, one after
+// the other.
+//
+// Each bitmap contributes its bits up to its End, such that bitmaps filled by
+// the Append methods, such as those of the LOUDS-DENSE builder, are joined
+// seamlessly. Bits set past the End, e.g. by Set, are not included. The new
+// bitmap's capacity, as well as its End, is the total number of bits.
+func Concat(bitmaps ...*Bitmap) *Bitmap {
+	total := 0
+	for _, bm := range bitmaps {
+		total += bm.end
+	}
+
+	out := New(0, total)
+	for _, bm := range bitmaps {
+		// out has capacity for all bits, so this cannot fail.
+		CopyRange(out, out.end, bm, 0, bm.end)
+		out.end += bm.end
+	}
+
+	return out
+}
+
+// CopyRange copies n bits of src, starting at srcOff, to dst, starting at
+// dstOff. Bits previously set in their place are overwritten.
+//
+// Rather than copying the bits one by one, they are copied in chunks of up to
+// 64 bits, shifted into place. dst and src may be the same bitmap, with
+// overlapping ranges.
+//
+// Like Set, CopyRange does not affect dst's End.
+//
+// An error is returned if the range to copy from is not within src's length,
+// or if the range to copy to exceeds dst's capacity.
+func CopyRange(dst *Bitmap, dstOff int, src *Bitmap, srcOff, n int) error {
+	if n < 0 {
+		return fmt.Errorf("Number of bits must not be negative. Was %d", n)
+	}
+
+	if srcOff < 0 || srcOff+n > src.length {
+		return fmt.Errorf("Range [%d, %d) must be within [0, %d)", srcOff, srcOff+n, src.length)
+	}
+
+	if dstOff < 0 || dstOff+n > dst.Capacity {
+		return fmt.Errorf("Cannot copy %d bits to %d, capacity is %d", n, dstOff, dst.Capacity)
+	}
+
+	if n == 0 {
+		return nil
+	}
+
+	dst.resize(dstOff + n)
+
+	// Chunks end at word boundaries of dst, such that each is written to a
+	// single word.
+	if dst == src && dstOff > srcOff && dstOff < srcOff+n {
+		// Copying forward would overwrite source bits before they
+		// were read, so we copy backward instead.
+		for left := n; left > 0; {
+			chunk := (dstOff+left-1)%64 + 1
+			if chunk > left {
+				chunk = left
+			}
+			left -= chunk
+
+			dst.writeBits(dstOff+left, src.readBits(srcOff+left, chunk), chunk)
+		}
+
+		return nil
+	}
+
+	for done := 0; done < n; {
+		chunk := 64 - (dstOff+done)%64
+		if chunk > n-done {
+			chunk = n - done
+		}
+
+		dst.writeBits(dstOff+done, src.readBits(srcOff+done, chunk), chunk)
+		done += chunk
+	}
+
+	return nil
+}
+
+// readBits returns the n bits starting at pos as the last n bits of a uint64.
+//
+// n must be in [0, 64], and the bits must be within the bitmap's length.
+func (bm *Bitmap) readBits(pos, n int) uint64 {
+	idx := pos / 64
+	offset := pos % 64
+
+	if offset+n <= 64 {
+		// Shifting by 64 yields 0, which covers n = 0.
+		return bitops.LastBits(n, bm.data[idx]>>(64-offset-n))
+	}
+
+	return bitops.GatherBits(bm.data[idx], bm.data[idx+1], n, offset)
+}
+
+// writeBits writes the last n bits of word, starting at pos. Any bits
+// previously set in their place are overwritten.
+//
+// n must be in [0, 64], and the bits must be within the bitmap's length.
+func (bm *Bitmap) writeBits(pos int, word uint64, n int) {
+	idx := pos / 64
+	offset := pos % 64
+
+	first, second := bitops.SpreadBits(word, n, offset)
+	firstMask, secondMask := bitops.SpreadBits(bitops.TrailingOnesMask(n), n, offset)
+
+	bm.data[idx] = bm.data[idx]&^firstMask | first
+	if secondMask != 0 {
+		bm.data[idx+1] = bm.data[idx+1]&^secondMask | second
+	}
+	bm.dropRankIndex()
+}
@@ -0,0 +1,148 @@
+package bitmap
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// randomBits returns a bitmap of size bits, each set with probability 1/2,
+// along with the bits as bytes.
+func randomBits(rng *rand.Rand, size, capacity int) (*Bitmap, []byte) {
+	bm := New(size, capacity)
+	bits := make([]byte, size)
+	for i := range bits {
+		if rng.Intn(2) == 1 {
+			bm.Set(i)
+			bits[i] = 1
+		}
+	}
+
+	return bm, bits
+}
+
+func TestCopyRange(t *testing.T) {
+	rng := rand.New(rand.NewSource(42))
+
+	for i := 0; i < 500; i++ {
+		src, _ := randomBits(rng, 1+rng.Intn(400), 400)
+		dst, expected := randomBits(rng, rng.Intn(400), 500)
+		expected = append(expected, make([]byte, 500-len(expected))...)
+
+		n := rng.Intn(src.Len() + 1)
+		srcOff := rng.Intn(src.Len() - n + 1)
+		dstOff := rng.Intn(500 - n + 1)
+		for j := 0; j < n; j++ {
+			expected[dstOff+j] = bitAt(src, srcOff+j)
+		}
+
+		assert.Nil(t, CopyRange(dst, dstOff, src, srcOff, n))
+		for j, val := range expected {
+			if !assert.Equal(t, val, bitAt(dst, j), "Bit %d after copying %d bits from %d to %d", j, n, srcOff, dstOff) {
+				break
+			}
+		}
+	}
+}
+
+func TestCopyRangeOverlapping(t *testing.T) {
+	rng := rand.New(rand.NewSource(42))
+
+	for i := 0; i < 500; i++ {
+		bm, bits := randomBits(rng, 400, 400)
+
+		n := rng.Intn(300)
+		srcOff := rng.Intn(400 - n + 1)
+		dstOff := rng.Intn(400 - n + 1)
+
+		expected := append([]byte{}, bits...)
+		copy(expected[dstOff:], bits[srcOff:srcOff+n])
+
+		assert.Nil(t, CopyRange(bm, dstOff, bm, srcOff, n))
+		for j, val := range expected {
+			if !assert.Equal(t, val, bitAt(bm, j), "Bit %d after copying %d bits from %d to %d", j, n, srcOff, dstOff) {
+				break
+			}
+		}
+	}
+}
+
+func TestCopyRangeInvalid(t *testing.T) {
+	src := New(128, 128)
+	dst := New(0, 100)
+
+	assert.NotNil(t, CopyRange(dst, 0, src, 0, -1))
+	assert.NotNil(t, CopyRange(dst, 0, src, -1, 10))
+	assert.NotNil(t, CopyRange(dst, 0, src, 120, 10))
+	assert.NotNil(t, CopyRange(dst, -1, src, 0, 10))
+	assert.NotNil(t, CopyRange(dst, 95, src, 0, 10))
+
+	assert.Nil(t, CopyRange(dst, 90, src, 118, 10))
+	assert.Nil(t, CopyRange(dst, 100, src, 128, 0))
+}
+
+func TestSlice(t *testing.T) {
+	rng := rand.New(rand.NewSource(42))
+	bm, bits := randomBits(rng, 300, 300)
+
+	for _, r := range [][2]int{{0, 0}, {0, 300}, {0, 64}, {3, 67}, {63, 65}, {100, 300}, {299, 300}} {
+		slice, err := bm.Slice(r[0], r[1])
+		assert.Nil(t, err)
+		assert.Equal(t, r[1]-r[0], slice.Capacity)
+		assert.Equal(t, r[1]-r[0], slice.End())
+
+		for j := 0; j < slice.Len(); j++ {
+			expected := byte(0)
+			if r[0]+j < r[1] {
+				expected = bits[r[0]+j]
+			}
+			assert.Equal(t, expected, bitAt(slice, j), "Bit %d of slice [%d, %d)", j, r[0], r[1])
+		}
+	}
+
+	for _, r := range [][2]int{{-1, 10}, {10, 9}, {0, 321}} {
+		_, err := bm.Slice(r[0], r[1])
+		assert.NotNil(t, err, "Slice [%d, %d)", r[0], r[1])
+	}
+}
+
+func TestConcat(t *testing.T) {
+	rng := rand.New(rand.NewSource(42))
+
+	bitmaps := make([]*Bitmap, 0)
+	expected := make([]byte, 0)
+	for _, size := range []int{0, 5, 64, 1, 130, 63, 0, 200} {
+		bm := New(0, size+64)
+		for i := 0; i < size; i++ {
+			bit := byte(rng.Intn(2))
+			bm.AppendBit(bit)
+			expected = append(expected, bit)
+		}
+
+		bitmaps = append(bitmaps, bm)
+	}
+
+	// Bits past the End are not included
+	assert.Nil(t, bitmaps[1].Set(10))
+
+	out := Concat(bitmaps...)
+	assert.Equal(t, len(expected), out.End())
+	assert.Equal(t, len(expected), out.Capacity)
+	for i, val := range expected {
+		assert.Equal(t, val, bitAt(out, i), "Bit %d", i)
+	}
+
+	assert.Equal(t, 0, Concat().End())
+}
+
+func BenchmarkCopyRange(b *testing.B) {
+	rng := rand.New(rand.NewSource(42))
+	src, _ := randomBits(rng, 100_000, 100_000)
+	dst := New(100_000, 100_000)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		CopyRange(dst, 13, src, 50, 99_000)
+	}
+}
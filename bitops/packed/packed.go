@@ -0,0 +1,205 @@
+// packed provides arrays of fixed-width unsigned integers, packed tightly
+// across uint64s.
+//
+// Integers may be of any width from 1 to 64 bits. As with bitmaps, bits are
+// stored from the most significant bit of each uint64 onwards, such that the
+// i-th integer occupies bits [i * width, (i + 1) * width) of the array.
+package packed
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/Lavode/surf/bitops"
+)
+
+// Array is an array of unsigned integers of a fixed width.
+type Array struct {
+	// width is the number of bits per integer
+	width int
+	// length is the number of integers in the array
+	length int
+	data   []uint64
+}
+
+// New initializes a new array of length integers of width bits each, all of
+// which are 0.
+//
+// An error is returned if width is not in [1, 64], or length is negative.
+func New(width, length int) (*Array, error) {
+	if width < 1 || width > 64 {
+		return nil, fmt.Errorf("Width must be in [1, 64]. Was %d", width)
+	}
+
+	if length < 0 {
+		return nil, fmt.Errorf("Length must not be negative. Was %d", length)
+	}
+
+	return &Array{width: width, length: length, data: make([]uint64, words(width, length))}, nil
+}
+
+// Width returns the number of bits per integer.
+func (a *Array) Width() int {
+	return a.width
+}
+
+// Len returns the number of integers in the array.
+func (a *Array) Len() int {
+	return a.length
+}
+
+// Get retrieves the integer at a given index.
+//
+// An error is returned if the index is invalid.
+func (a *Array) Get(i int) (uint64, error) {
+	if i < 0 || i >= a.length {
+		return 0, fmt.Errorf("Invalid index %d. Must be in range [0, %d]", i, a.length-1)
+	}
+
+	return a.get(i * a.width), nil
+}
+
+// Set sets the integer at a given index.
+//
+// An error is returned if the index is invalid, or if the value does not fit
+// into the array's width.
+func (a *Array) Set(i int, v uint64) error {
+	if i < 0 || i >= a.length {
+		return fmt.Errorf("Invalid index %d. Must be in range [0, %d]", i, a.length-1)
+	}
+
+	if v != bitops.LastBits(a.width, v) {
+		return fmt.Errorf("Value %d does not fit into %d bits", v, a.width)
+	}
+
+	a.set(i*a.width, v)
+
+	return nil
+}
+
+// Append adds an integer to the end of the array.
+//
+// An error is returned if the value does not fit into the array's width.
+func (a *Array) Append(v uint64) error {
+	if v != bitops.LastBits(a.width, v) {
+		return fmt.Errorf("Value %d does not fit into %d bits", v, a.width)
+	}
+
+	a.length++
+	for len(a.data) < words(a.width, a.length) {
+		a.data = append(a.data, 0)
+	}
+
+	a.set((a.length-1)*a.width, v)
+
+	return nil
+}
+
+// Decode retrieves len(out) consecutive integers, starting at index from,
+// into out.
+//
+// It is cheaper than calling Get for each of them, as it avoids repeated
+// bounds checks and computation of offsets.
+//
+// An error is returned if the integers are not all within the array.
+func (a *Array) Decode(from int, out []uint64) error {
+	if from < 0 || from+len(out) > a.length {
+		return fmt.Errorf("Range [%d, %d) must be within [0, %d)", from, from+len(out), a.length)
+	}
+
+	bit := from * a.width
+	for i := range out {
+		out[i] = a.get(bit)
+		bit += a.width
+	}
+
+	return nil
+}
+
+// get retrieves the integer starting at the given bit.
+func (a *Array) get(bit int) uint64 {
+	idx := bit / 64
+	offset := bit % 64
+
+	var second uint64
+	if offset+a.width > 64 {
+		second = a.data[idx+1]
+	}
+
+	return bitops.GatherBits(a.data[idx], second, a.width, offset)
+}
+
+// set overwrites the integer starting at the given bit.
+func (a *Array) set(bit int, v uint64) {
+	idx := bit / 64
+	offset := bit % 64
+
+	first, second := bitops.SpreadBits(v, a.width, offset)
+	firstMask, secondMask := bitops.SpreadBits(bitops.TrailingOnesMask(a.width), a.width, offset)
+
+	a.data[idx] = a.data[idx]&^firstMask | first
+	if secondMask != 0 {
+		a.data[idx+1] = a.data[idx+1]&^secondMask | second
+	}
+}
+
+// MarshalBinary encodes the array.
+//
+// The encoding consists of the width and length, followed by the array's
+// content, as big-endian uint64s.
+func (a *Array) MarshalBinary() ([]byte, error) {
+	out := make([]byte, 16+8*len(a.data))
+
+	binary.BigEndian.PutUint64(out, uint64(a.width))
+	binary.BigEndian.PutUint64(out[8:], uint64(a.length))
+	for i, word := range a.data {
+		binary.BigEndian.PutUint64(out[16+8*i:], word)
+	}
+
+	return out, nil
+}
+
+// UnmarshalBinary decodes an array previously encoded with MarshalBinary,
+// replacing the array's content.
+//
+// An error is returned if the encoding is malformed.
+func (a *Array) UnmarshalBinary(data []byte) error {
+	if len(data) < 16 || len(data)%8 != 0 {
+		return fmt.Errorf("Encoded array must be a multiple of 8 bytes, and at least 16 bytes long. Was %d", len(data))
+	}
+
+	width := binary.BigEndian.Uint64(data)
+	if width < 1 || width > 64 {
+		return fmt.Errorf("Encoded width must be in [1, 64]. Was %d", width)
+	}
+
+	// Checking against the number of words before multiplying with the
+	// width ensures the length cannot overflow.
+	n := len(data)/8 - 2
+	length := binary.BigEndian.Uint64(data[8:])
+	if length > uint64(64*n) || words(int(width), int(length)) != n {
+		return fmt.Errorf("Encoded array length %d of width %d does not match its %d words of content", length, width, n)
+	}
+
+	a.width = int(width)
+	a.length = int(length)
+	a.data = make([]uint64, n)
+	for i := range a.data {
+		a.data[i] = binary.BigEndian.Uint64(data[16+8*i:])
+	}
+
+	return nil
+}
+
+// words returns the number of uint64s needed to hold length integers of the
+// given width.
+func words(width, length int) int {
+	bits := width * length
+
+	n := bits / 64
+	if bits%64 != 0 {
+		n++
+	}
+
+	return n
+}
@@ -0,0 +1,171 @@
+package packed
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/Lavode/surf/bitops"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNew(t *testing.T) {
+	a, err := New(5, 100)
+	assert.Nil(t, err)
+	assert.Equal(t, 5, a.Width())
+	assert.Equal(t, 100, a.Len())
+	assert.Equal(t, 8, len(a.data))
+
+	for i := 0; i < 100; i++ {
+		v, err := a.Get(i)
+		assert.Nil(t, err)
+		assert.Equal(t, uint64(0), v)
+	}
+
+	_, err = New(0, 10)
+	assert.Error(t, err)
+
+	_, err = New(65, 10)
+	assert.Error(t, err)
+
+	_, err = New(8, -1)
+	assert.Error(t, err)
+}
+
+func TestSetGetAppend(t *testing.T) {
+	rng := rand.New(rand.NewSource(42))
+
+	for width := 1; width <= 64; width++ {
+		expected := make([]uint64, 200)
+		for i := range expected {
+			expected[i] = bitops.LastBits(width, rng.Uint64())
+		}
+
+		// Filling a preallocated array in random order
+		set, err := New(width, len(expected))
+		assert.Nil(t, err)
+		for _, i := range rng.Perm(len(expected)) {
+			assert.Nil(t, set.Set(i, expected[i]))
+		}
+
+		// Appending to an empty one
+		appended, err := New(width, 0)
+		assert.Nil(t, err)
+		for _, v := range expected {
+			assert.Nil(t, appended.Append(v))
+		}
+		assert.Equal(t, len(expected), appended.Len())
+
+		for i, v := range expected {
+			actual, err := set.Get(i)
+			assert.Nil(t, err)
+			assert.Equal(t, v, actual, "Width %d, index %d", width, i)
+
+			actual, err = appended.Get(i)
+			assert.Nil(t, err)
+			assert.Equal(t, v, actual, "Width %d, index %d", width, i)
+		}
+
+		// Overwriting must not affect neighbours
+		assert.Nil(t, set.Set(100, bitops.LastBits(width, ^expected[100])))
+		for i := 99; i <= 101; i++ {
+			actual, _ := set.Get(i)
+			if i == 100 {
+				assert.Equal(t, bitops.LastBits(width, ^expected[i]), actual)
+			} else {
+				assert.Equal(t, expected[i], actual)
+			}
+		}
+	}
+}
+
+func TestInvalidAccess(t *testing.T) {
+	a, err := New(4, 10)
+	assert.Nil(t, err)
+
+	_, err = a.Get(-1)
+	assert.Error(t, err)
+	_, err = a.Get(10)
+	assert.Error(t, err)
+
+	assert.Error(t, a.Set(10, 1))
+	assert.Error(t, a.Set(3, 16))
+	assert.Error(t, a.Append(16))
+	assert.Equal(t, 10, a.Len())
+
+	assert.Error(t, a.Decode(-1, make([]uint64, 2)))
+	assert.Error(t, a.Decode(9, make([]uint64, 2)))
+}
+
+func TestDecode(t *testing.T) {
+	a, err := New(13, 0)
+	assert.Nil(t, err)
+	for i := 0; i < 300; i++ {
+		assert.Nil(t, a.Append(uint64(i)))
+	}
+
+	out := make([]uint64, 50)
+	assert.Nil(t, a.Decode(217, out))
+	for i, v := range out {
+		assert.Equal(t, uint64(217+i), v)
+	}
+
+	assert.Nil(t, a.Decode(300, out[:0]))
+}
+
+func TestMarshalBinary(t *testing.T) {
+	a, err := New(23, 0)
+	assert.Nil(t, err)
+	for i := 0; i < 77; i++ {
+		assert.Nil(t, a.Append(uint64(i*i*i)%(1<<23)))
+	}
+
+	data, err := a.MarshalBinary()
+	assert.Nil(t, err)
+
+	decoded := &Array{}
+	assert.Nil(t, decoded.UnmarshalBinary(data))
+	assert.Equal(t, a, decoded)
+}
+
+func TestUnmarshalBinaryInvalid(t *testing.T) {
+	a, err := New(7, 20)
+	assert.Nil(t, err)
+	data, err := a.MarshalBinary()
+	assert.Nil(t, err)
+
+	tests := map[string]func([]byte) []byte{
+		"Truncated":      func(data []byte) []byte { return data[:len(data)-8] },
+		"Unaligned":      func(data []byte) []byte { return data[:len(data)-1] },
+		"Zero width":     func(data []byte) []byte { data[7] = 0; return data },
+		"Width too wide": func(data []byte) []byte { data[7] = 65; return data },
+		"Length":         func(data []byte) []byte { data[15] = 200; return data },
+		"Huge length":    func(data []byte) []byte { data[8] = 0xFF; return data },
+	}
+
+	for name, corrupt := range tests {
+		corrupted := corrupt(append([]byte{}, data...))
+		assert.Error(t, (&Array{}).UnmarshalBinary(corrupted), name)
+	}
+}
+
+func BenchmarkGet(b *testing.B) {
+	a, _ := New(13, 1<<16)
+	out := make([]uint64, a.Len())
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for j := range out {
+			out[j], _ = a.Get(j)
+		}
+	}
+}
+
+func BenchmarkDecode(b *testing.B) {
+	a, _ := New(13, 1<<16)
+	out := make([]uint64, a.Len())
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		a.Decode(0, out)
+	}
+}
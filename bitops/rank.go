@@ -0,0 +1,58 @@
+package bitops
+
+import "math/bits"
+
+// selectInByte holds, for each byte value b and each k in [0, 7], the
+// position of the (k+1)-th 1-bit of b, counted from the most significant bit.
+// Entries for 1-bits which b lacks are 8.
+var selectInByte = func() (table [256][8]uint8) {
+	for b := 0; b < 256; b++ {
+		for k := range table[b] {
+			table[b][k] = 8
+		}
+
+		k := 0
+		for pos := 0; pos < 8; pos++ {
+			if b&(0x80>>pos) != 0 {
+				table[b][k] = uint8(pos)
+				k++
+			}
+		}
+	}
+
+	return table
+}()
+
+// Rank64 returns the number of 1-bits of word up to and including position
+// pos.
+//
+// As elsewhere, bits are counted from the most significant one. pos must be in
+// [0, 63].
+func Rank64(word uint64, pos int) int {
+	return bits.OnesCount64(word >> (63 - pos))
+}
+
+// Select64 returns the position of the k-th 1-bit of word, counting k from 1.
+//
+// As elsewhere, bits are counted from the most significant one. If word has
+// fewer than k 1-bits, or k is not positive, 64 is returned.
+//
+// The byte holding the bit is found by counting the 1-bits of one byte after
+// the other, and the bit within it by a table lookup. This needs no special
+// instructions, so is portable to all platforms.
+func Select64(word uint64, k int) int {
+	if k <= 0 || k > bits.OnesCount64(word) {
+		return 64
+	}
+
+	for shift := 56; ; shift -= 8 {
+		b := uint8(word >> shift)
+
+		cnt := bits.OnesCount8(b)
+		if k <= cnt {
+			return 56 - shift + int(selectInByte[b][k-1])
+		}
+
+		k -= cnt
+	}
+}
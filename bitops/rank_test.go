@@ -0,0 +1,84 @@
+package bitops
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// naiveRank64 counts the 1-bits of word up to and including pos one by one.
+func naiveRank64(word uint64, pos int) int {
+	cnt := 0
+	for i := 0; i <= pos; i++ {
+		if word&SingleOneMask(i) != 0 {
+			cnt++
+		}
+	}
+
+	return cnt
+}
+
+// naiveSelect64 returns the position of the k-th 1-bit of word, found bit by
+// bit.
+func naiveSelect64(word uint64, k int) int {
+	for i := 0; i < 64; i++ {
+		if word&SingleOneMask(i) != 0 {
+			k--
+			if k == 0 {
+				return i
+			}
+		}
+	}
+
+	return 64
+}
+
+// testWords returns words to check in-word rank and select on: Edge cases, as
+// well as random words of varying density.
+func testWords() []uint64 {
+	rng := rand.New(rand.NewSource(42))
+
+	words := []uint64{0, 0xFFFFFFFFFFFFFFFF, 0x8000000000000000, 1, 0x8000000000000001, 0xAAAAAAAAAAAAAAAA}
+	for i := 0; i < 1000; i++ {
+		words = append(words, rng.Uint64(), rng.Uint64()&rng.Uint64()&rng.Uint64(), rng.Uint64()|rng.Uint64())
+	}
+
+	return words
+}
+
+func TestRank64(t *testing.T) {
+	assert.Equal(t, 1, Rank64(0x8000000000000000, 0))
+	assert.Equal(t, 0, Rank64(1, 62))
+	assert.Equal(t, 1, Rank64(1, 63))
+
+	for _, word := range testWords() {
+		for pos := 0; pos < 64; pos++ {
+			assert.Equal(t, naiveRank64(word, pos), Rank64(word, pos), "Rank64(%x, %d)", word, pos)
+		}
+	}
+}
+
+func TestSelect64(t *testing.T) {
+	assert.Equal(t, 0, Select64(0x8000000000000000, 1))
+	assert.Equal(t, 63, Select64(0x8000000000000001, 2))
+	assert.Equal(t, 64, Select64(0x8000000000000001, 3))
+	assert.Equal(t, 64, Select64(0xFFFFFFFFFFFFFFFF, 0))
+	assert.Equal(t, 64, Select64(0, 1))
+
+	for _, word := range testWords() {
+		for k := -1; k <= 65; k++ {
+			assert.Equal(t, naiveSelect64(word, k), Select64(word, k), "Select64(%x, %d)", word, k)
+		}
+	}
+}
+
+func BenchmarkSelect64(b *testing.B) {
+	words := testWords()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		word := words[i%len(words)]
+		Select64(word, 1+i%64)
+	}
+}
@@ -0,0 +1,37 @@
+package bitops
+
+// SpreadBits positions the last n bits of value such that they start at bit
+// offset of a word, for writing values which may cross word boundaries.
+//
+// As elsewhere, bits are counted from the most significant one. The bits
+// falling into the word are returned as first, those overflowing into the
+// next word as second. Both can be ORed into their respective words. Writing
+// n 1-bits, i.e. TrailingOnesMask(n), yields the masks of the bits written.
+//
+// n must be in [0, 64] and offset in [0, 63].
+func SpreadBits(value uint64, n, offset int) (first, second uint64) {
+	// Move the value's bits to the top of the word, discarding all others.
+	// Go defines shifts by 64 or more to yield 0, which covers n = 0 as
+	// well as offset = 0.
+	top := LastBits(n, value) << (64 - n)
+
+	first = top >> offset
+	second = top << (64 - offset)
+
+	return first, second
+}
+
+// GatherBits is the inverse of SpreadBits. It returns the n bits starting at
+// bit offset of first, continuing into second if they cross the word
+// boundary, as the last n bits of the result.
+//
+// n must be in [0, 64] and offset in [0, 63].
+func GatherBits(first, second uint64, n, offset int) uint64 {
+	if n == 0 {
+		return 0
+	}
+
+	top := first<<offset | second>>(64-offset)
+
+	return top >> (64 - n)
+}
@@ -0,0 +1,52 @@
+package bitops
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSpreadBits(t *testing.T) {
+	tests := []struct {
+		value  uint64
+		n      int
+		offset int
+		first  uint64
+		second uint64
+	}{
+		{0b101, 3, 0, 0xA000000000000000, 0},
+		{0b101, 3, 61, 0b101, 0},
+		{0b101, 3, 62, 0b10, 0x8000000000000000},
+		{0b101, 3, 63, 0b1, 0x4000000000000000},
+		// Bits beyond the last n are ignored
+		{0xFF, 4, 0, 0xF000000000000000, 0},
+		{0xFFFFFFFFFFFFFFFF, 64, 0, 0xFFFFFFFFFFFFFFFF, 0},
+		{0xFFFFFFFFFFFFFFFF, 64, 8, 0x00FFFFFFFFFFFFFF, 0xFF00000000000000},
+		{0xFFFFFFFFFFFFFFFF, 0, 17, 0, 0},
+	}
+
+	for _, test := range tests {
+		first, second := SpreadBits(test.value, test.n, test.offset)
+		assert.Equal(t, test.first, first, "SpreadBits(%x, %d, %d)", test.value, test.n, test.offset)
+		assert.Equal(t, test.second, second, "SpreadBits(%x, %d, %d)", test.value, test.n, test.offset)
+	}
+}
+
+func TestGatherBits(t *testing.T) {
+	rng := rand.New(rand.NewSource(42))
+
+	for n := 0; n <= 64; n++ {
+		for offset := 0; offset < 64; offset++ {
+			value := LastBits(n, rng.Uint64())
+
+			// Random surrounding bits must not leak into the result.
+			first, second := SpreadBits(value, n, offset)
+			mask1, mask2 := SpreadBits(TrailingOnesMask(n), n, offset)
+			first |= rng.Uint64() &^ mask1
+			second |= rng.Uint64() &^ mask2
+
+			assert.Equal(t, value, GatherBits(first, second, n, offset), "GatherBits(%d, %d)", n, offset)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+// surf-fpr measures the false-positive rate of a SuRF store built from a key
+// set, and prints the results as a table.
+//
+// Keys are read from a file, one per line, or generated at random if no file
+// is given. Negative point and range queries are produced by each of the
+// generators of package fpr.
+//
+// Stores are built with each configuration given by a -config flag, such that
+// they may be compared. A configuration is a comma-separated list of options,
+// such as "labels=eliasfano,layout=interleaved". See parseConfig for the
+// options which are understood.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/Lavode/surf/store"
+	"github.com/Lavode/surf/store/fpr"
+	"golang.org/x/exp/slices"
+)
+
+func main() {
+	keysPath := flag.String("keys", "", "File to read keys from, one per line. If empty, random keys are generated")
+	keyCount := flag.Int("n", 10_000, "Number of random keys to generate if no key file is given")
+	maxKeyLength := flag.Int("max-key-length", 16, "Maximum length of random keys, respectively of random query suffixes")
+	queries := flag.Int("queries", 10_000, "Number of negative point and range queries per generator")
+	rangeWidth := flag.Int("range-width", 16, "Maximum width of range queries")
+	seed := flag.Int64("seed", 42, "Seed for key and query generation")
+	var configs []fpr.Config
+	flag.Func("config", "Store configuration to measure, e.g. \"labels=eliasfano,layout=interleaved\". May be repeated. If none is given, the default options are measured", func(spec string) error {
+		config, err := parseConfig(spec)
+		if err != nil {
+			return err
+		}
+
+		configs = append(configs, config)
+		return nil
+	})
+	flag.Parse()
+
+	if len(configs) == 0 {
+		configs = []fpr.Config{{Name: "default"}}
+	}
+
+	var keys [][]byte
+	var err error
+	if *keysPath != "" {
+		keys, err = readKeys(*keysPath)
+		if err != nil {
+			log.Fatalf("Error reading keys: %v", err)
+		}
+	} else {
+		keys = randomKeys(rand.New(rand.NewSource(*seed)), *keyCount, *maxKeyLength)
+	}
+
+	// Stores must not be built from duplicate keys.
+	slices.SortFunc(keys, func(a, b []byte) bool {
+		return bytes.Compare(a, b) < 0
+	})
+	keys = slices.CompactFunc(keys, bytes.Equal)
+
+	generators := []fpr.Generator{
+		fpr.Random(1, *maxKeyLength),
+		fpr.NearMiss(),
+		fpr.SharedPrefix(*maxKeyLength),
+	}
+
+	harness := fpr.Harness{
+		Keys:       keys,
+		Queries:    *queries,
+		RangeWidth: *rangeWidth,
+		Seed:       *seed,
+	}
+
+	results, err := harness.Measure(configs, generators)
+	if err != nil {
+		log.Fatalf("Error measuring false-positive rate: %v", err)
+	}
+
+	fmt.Printf("%d keys\n\n", len(keys))
+	err = fpr.WriteTable(os.Stdout, results)
+	if err != nil {
+		log.Fatalf("Error writing results: %v", err)
+	}
+}
+
+// parseConfig parses a configuration given as a comma-separated list of
+// name=value options. The following options are understood:
+//   - r, hash-bits, real-bits and memory-limit, taking numbers
+//   - labels, has-child and is-prefix-key, taking a bitmap encoding, one of
+//     plain and eliasfano
+//   - layout, taking a dense layout, one of separate and interleaved
+//
+// The configuration is named after spec. Its options are validated when the
+// store is built.
+func parseConfig(spec string) (fpr.Config, error) {
+	config := fpr.Config{Name: spec}
+
+	for _, option := range strings.Split(spec, ",") {
+		name, value, found := strings.Cut(option, "=")
+		if !found {
+			return fpr.Config{}, fmt.Errorf("Option %q is not of the form name=value", option)
+		}
+
+		var opt store.Option
+		var err error
+		switch name {
+		case "r":
+			opt, err = uintOption(value, store.WithR)
+		case "hash-bits":
+			opt, err = uintOption(value, store.WithHashBits)
+		case "real-bits":
+			opt, err = uintOption(value, store.WithRealBits)
+		case "memory-limit":
+			var bits int
+			bits, err = strconv.Atoi(value)
+			opt = store.WithMemoryLimit(bits)
+		case "labels":
+			opt, err = encodingOption(value, store.WithLabelsEncoding)
+		case "has-child":
+			opt, err = encodingOption(value, store.WithHasChildEncoding)
+		case "is-prefix-key":
+			opt, err = encodingOption(value, store.WithIsPrefixKeyEncoding)
+		case "layout":
+			switch value {
+			case "separate":
+				opt = store.WithDenseLayout(store.LayoutSeparate)
+			case "interleaved":
+				opt = store.WithDenseLayout(store.LayoutInterleaved)
+			default:
+				err = fmt.Errorf("Unknown layout %q", value)
+			}
+		default:
+			err = fmt.Errorf("Unknown option %q", name)
+		}
+		if err != nil {
+			return fpr.Config{}, err
+		}
+
+		config.Options = append(config.Options, opt)
+	}
+
+	return config, nil
+}
+
+// uintOption returns the option set by with to the number value.
+func uintOption(value string, with func(uint) store.Option) (store.Option, error) {
+	n, err := strconv.ParseUint(value, 10, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return with(uint(n)), nil
+}
+
+// encodingOption returns the option set by with to the bitmap encoding named
+// by value.
+func encodingOption(value string, with func(store.BitmapEncoding) store.Option) (store.Option, error) {
+	switch value {
+	case "plain":
+		return with(store.EncodingPlain), nil
+	case "eliasfano":
+		return with(store.EncodingEliasFano), nil
+	default:
+		return nil, fmt.Errorf("Unknown bitmap encoding %q", value)
+	}
+}
+
+// readKeys reads keys from the file at path, one per line.
+func readKeys(path string) ([][]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var keys [][]byte
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// The scanner reuses its buffer, so the line must be copied.
+		keys = append(keys, slices.Clone(scanner.Bytes()))
+	}
+
+	return keys, scanner.Err()
+}
+
+// randomKeys returns n random keys with lengths in [1, maxLength].
+func randomKeys(rng *rand.Rand, n, maxLength int) [][]byte {
+	keys := make([][]byte, n)
+	for i := range keys {
+		keys[i] = make([]byte, 1+rng.Intn(maxLength))
+		rng.Read(keys[i])
+	}
+
+	return keys
+}
@@ -2,10 +2,13 @@ module github.com/Lavode/surf
 
 go 1.18
 
+require (
+	github.com/stretchr/testify v1.7.1
+	golang.org/x/exp v0.0.0-20220428152302-39d4317da171
+)
+
 require (
 	github.com/davecgh/go-spew v1.1.0 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
-	github.com/stretchr/testify v1.7.1 // indirect
-	golang.org/x/exp v0.0.0-20220428152302-39d4317da171 // indirect
 	gopkg.in/yaml.v3 v3.0.0-20200313102051-9f266ea9e77c // indirect
 )
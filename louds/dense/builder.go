@@ -1,9 +1,11 @@
 package dense
 
 import (
+	"bytes"
 	"fmt"
 
 	"github.com/Lavode/surf/bitmap"
+	"github.com/Lavode/surf/bitops"
 	"github.com/Lavode/surf/louds"
 )
 
@@ -57,21 +59,38 @@ type Builder struct {
 	// currentNodeId is the 0-indexed level-order ID of the node we are
 	// currently building up.
 	currentNodeId int
+
+	// labels, hasChild and isPrefixKey hold the bits of the node
+	// currently being built up. They are appended to the bitmaps at once
+	// when the node is complete, rather than setting the bits one by one.
+	labels      [4]uint64
+	hasChild    [4]uint64
+	isPrefixKey byte
+
+	// memoryLimit is the memory limit, in bits, the builder was created
+	// with.
+	memoryLimit int
 }
 
+// bitsPerNode is the number of bits each node occupies across the D-Labels,
+// D-HasChild and D-IsPrefixKey bitmaps.
+const bitsPerNode = 256 + 256 + 1
+
 // NewBuilder instantiates a new LOUDS-DENSE builder.
 //
-// memory_limit specifies the memory limits in bits.
+// memory_limit specifies the memory limits in bits. It limits the size of the
+// tree, but memory is only reserved as the tree grows.
 func NewBuilder(memory_limit int) *Builder {
 	// Labels and HasChild are 256 bit per node, IsPrefixKey is 1 bit per
 	// node.
-	memory_unit := memory_limit / (256 + 256 + 1)
+	memory_unit := memory_limit / bitsPerNode
 
 	builder := Builder{
-		Labels:      bitmap.New(256, 256*memory_unit),
-		HasChild:    bitmap.New(256, 256*memory_unit),
-		IsPrefixKey: bitmap.New(1, memory_unit),
+		Labels:      bitmap.New(0, 256*memory_unit),
+		HasChild:    bitmap.New(0, 256*memory_unit),
+		IsPrefixKey: bitmap.New(0, memory_unit),
 		tasks:       make([]*NodeTask, 0),
+		memoryLimit: memory_limit,
 	}
 
 	return &builder
@@ -85,13 +104,47 @@ func (builder *Builder) NodeCapacity() int {
 
 // Build instantiates a LOUDS-DENSE encoded tree using the given keys.
 //
+// The keys must be sorted and free of duplicates. If they are not, an error
+// wrapping louds.ErrUnsortedInput or louds.ErrDuplicateKey is returned.
+//
+// The empty key is supported, and encoded by setting the root node's
+// is-prefix-key flag.
+//
+// If the tree would need more nodes than the builder's memory limit allows
+// for, a *louds.MemoryLimitError is returned before anything is built.
+//
 // Build may only be called on a freshly created instance. Calling Build on a
 // builder more than once is not guaranteed to produce a consistent tree.
 func (builder *Builder) Build(keys []louds.Key) error {
+	err := louds.ValidateKeys(keys)
+	if err != nil {
+		return fmt.Errorf("LOUDS-Dense builder: %w", err)
+	}
+
+	if nodes := nodeCount(keys); nodes > builder.NodeCapacity() {
+		return fmt.Errorf("LOUDS-Dense builder: %w", &louds.MemoryLimitError{
+			Required:  nodes * bitsPerNode,
+			Available: builder.memoryLimit,
+		})
+	}
+
 	// For depth = 0 we'll consider all keys
 	builder.appendNodeTask()
 	builder.currentTask.keys = keys
 
+	// The root node always exists, and is the terminal node of the empty
+	// key. Being sorted, the empty key can only be the first one.
+	builder.initializeNode()
+
+	if len(keys) > 0 && len(keys[0]) == 0 {
+		builder.setIsPrefixKey()
+
+		// The root's task is processed like any other, so must carry
+		// the flag as well.
+		builder.currentTask.isPrefixKey = true
+		builder.currentTask.keys = keys[1:]
+	}
+
 	for depth := 0; depth < maxKeyLength(keys); depth++ {
 		// During iteration we'll be adding tasks of the next tree
 		// level. But we only want to consider tasks of the current
@@ -112,10 +165,7 @@ func (builder *Builder) Build(keys []louds.Key) error {
 			nodeHasEdges := false
 			var mostRecentEdge byte = 0x00
 
-			err := builder.initializeNode()
-			if err != nil {
-				return err
-			}
+			builder.initializeNode()
 
 			// If the node is non-empty (which is the case if we are here), and the task has
 			// its isPrefixKey flag set, then that means that one key ended on this node.
@@ -127,10 +177,7 @@ func (builder *Builder) Build(keys []louds.Key) error {
 				edge := key[depth]
 
 				if !nodeHasEdges || mostRecentEdge != edge {
-					err := builder.addEdge(edge)
-					if err != nil {
-						return err
-					}
+					builder.addEdge(edge)
 
 					// Having added a new edge means that there will also, on the next level,
 					// be a new node which future keys (if we're not at their end yet) will go into.
@@ -145,10 +192,7 @@ func (builder *Builder) Build(keys []louds.Key) error {
 					// have to have IsPrefixKey set to true
 					builder.currentTask.isPrefixKey = true
 				} else {
-					err := builder.setHasChild(edge)
-					if err != nil {
-						return nil
-					}
+					builder.setHasChild(edge)
 
 					builder.currentTask.keys = append(builder.currentTask.keys, key)
 				}
@@ -156,108 +200,87 @@ func (builder *Builder) Build(keys []louds.Key) error {
 			}
 
 			// Reached end of the current node.
-			builder.currentNodeId++
+			err = builder.finishNode()
+			if err != nil {
+				return err
+			}
 		}
 
 		// We processed all tasks of the current level, so we'll
 		// discard them.
 		builder.tasks = builder.tasks[n:]
 	}
-	return nil
-}
 
-// addEdge adds a new edge at the node being currently built up.
-func (builder *Builder) addEdge(edge byte) error {
-	bit := builder.labelOffset() + int(edge)
-	err := builder.Labels.Set(bit)
-
-	if err != nil {
-		return fmt.Errorf(
-			"LOUDS-Dense builder: Error setting bit for edge %x at %d: %v",
-			edge,
-			bit,
-			err,
-		)
+	// Without any non-empty keys, the root is never processed as a task,
+	// but exists nonetheless.
+	if builder.currentNodeId == 0 {
+		err = builder.finishNode()
+		if err != nil {
+			return err
+		}
 	}
 
-	return nil
-}
+	// The bitmaps grew as nodes were added. Now that the tree is complete,
+	// we release the memory reserved for further growth.
+	builder.Labels.ShrinkToFit()
+	builder.HasChild.ShrinkToFit()
+	builder.IsPrefixKey.ShrinkToFit()
 
-// setHasChild sets the has-child flag of the given edge at the node being
-// currently built up.
-func (builder *Builder) setHasChild(edge byte) error {
-	bit := builder.hasChildOffset() + int(edge)
-	err := builder.HasChild.Set(bit)
-
-	if err != nil {
-		return fmt.Errorf(
-			"LOUDS-Dense builder: Error setting bit for has-child of edge %x at %d: %v",
-			edge,
-			bit,
-			err,
-		)
-	}
+	// Following an edge takes a rank query over D-HasChild, which would
+	// otherwise scan the bitmap up to the edge.
+	builder.Labels.BuildRankIndex()
+	builder.HasChild.BuildRankIndex()
+	builder.IsPrefixKey.BuildRankIndex()
 
 	return nil
 }
 
-// setIsPrefixKey sets the is-prefix-key flag of the node currently being built
-// up.
-func (builder *Builder) setIsPrefixKey() error {
-	bit := builder.isPrefixKeyOffset()
-	err := builder.IsPrefixKey.Set(bit)
-
-	if err != nil {
-		return fmt.Errorf(
-			"LOUDS-Dense builder: Error setting bit for is-prefix-key at %d: %v",
-			bit,
-			err,
-		)
-	}
-
-	return nil
+// addEdge adds a new edge at the node being currently built up.
+func (builder *Builder) addEdge(edge byte) {
+	builder.labels[edge/64] |= bitops.SingleOneMask(int(edge % 64))
 }
 
-// labelOffset returns the offset in the D-Labels bitmap of the currently
-// processed node.
-func (builder *Builder) labelOffset() int {
-	return builder.currentNodeId * 256
+// setHasChild sets the has-child flag of the given edge at the node being
+// currently built up.
+func (builder *Builder) setHasChild(edge byte) {
+	builder.hasChild[edge/64] |= bitops.SingleOneMask(int(edge % 64))
 }
 
-// hasChildOffset returns the offset in the D-HasChild bitmap of the currently
-// processed node.
-func (builder *Builder) hasChildOffset() int {
-	return builder.currentNodeId * 256
+// setIsPrefixKey sets the is-prefix-key flag of the node currently being built
+// up.
+func (builder *Builder) setIsPrefixKey() {
+	builder.isPrefixKey = 1
 }
 
-// isPrefixKeyOffset returns the offset in the D-IsPrefixKey bitmap of the
-// currently processed node.
-func (builder *Builder) isPrefixKeyOffset() int {
-	return builder.currentNodeId
+// initializeNode starts building up the node with ID builder.currentNodeId,
+// with no edges and flags set.
+func (builder *Builder) initializeNode() {
+	builder.labels = [4]uint64{}
+	builder.hasChild = [4]uint64{}
+	builder.isPrefixKey = 0
 }
 
-// initializeNode initializes the various bitmaps to accomodate the new node
-// with ID builder.currentNodeId.
-func (builder *Builder) initializeNode() error {
-	// We'll make sure the current node's extents in the various bitmaps is
-	// allocated.
-	// This is not strictly needed, but makes for cleaner / easier to test
-	// results.
+// finishNode appends the node being currently built up to the bitmaps, and
+// moves on to the next node.
+func (builder *Builder) finishNode() error {
+	for i := range builder.labels {
+		err := builder.Labels.AppendBits(builder.labels[i], 64)
+		if err != nil {
+			return fmt.Errorf("LOUDS-Dense builder: Error appending labels of node %d: %v", builder.currentNodeId, err)
+		}
 
-	_, err := builder.Labels.Get(builder.labelOffset() + 255)
-	if err != nil {
-		return fmt.Errorf("LOUDS-Dense builder: Error initializing labels bitmap: %v", err)
+		err = builder.HasChild.AppendBits(builder.hasChild[i], 64)
+		if err != nil {
+			return fmt.Errorf("LOUDS-Dense builder: Error appending has-child of node %d: %v", builder.currentNodeId, err)
+		}
 	}
 
-	_, err = builder.HasChild.Get(builder.hasChildOffset() + 255)
+	err := builder.IsPrefixKey.AppendBit(builder.isPrefixKey)
 	if err != nil {
-		return fmt.Errorf("LOUDS-Dense builder: Error initializing has-child bitmap: %v", err)
+		return fmt.Errorf("LOUDS-Dense builder: Error appending is-prefix-key of node %d: %v", builder.currentNodeId, err)
 	}
 
-	_, err = builder.IsPrefixKey.Get(builder.isPrefixKeyOffset())
-	if err != nil {
-		return fmt.Errorf("LOUDS-Dense builder: Error initializing is-prefix-key bitmap: %v", err)
-	}
+	builder.currentNodeId++
 
 	return nil
 }
@@ -284,3 +307,75 @@ func maxKeyLength(keys []louds.Key) int {
 
 	return maxKeyLength
 }
+
+// EncodedSize returns the number of bits of the D-Labels, D-HasChild and
+// D-IsPrefixKey bitmaps needed to encode the given keys, without building the
+// tree.
+//
+// The keys must be sorted and unique. Their sum is the minimum memory limit
+// with which a builder is able to encode the keys.
+func EncodedSize(keys []louds.Key) (labels, hasChild, isPrefixKey int) {
+	nodes := nodeCount(keys)
+
+	return 256 * nodes, 256 * nodes, nodes
+}
+
+// EncodedOnes returns the number of 1-bits of the D-Labels, D-HasChild and
+// D-IsPrefixKey bitmaps encoding the given keys, without building the tree.
+//
+// The keys must be sorted and unique.
+func EncodedOnes(keys []louds.Key) (labels, hasChild, isPrefixKey int) {
+	// Each node other than the root is the child of exactly one edge.
+	hasChild = nodeCount(keys) - 1
+
+	// A key ends in a node if it is the empty key, or if some other key
+	// extends it, which with sorted keys is the one following it. All
+	// other keys end in an edge without a child.
+	for i, key := range keys {
+		if len(key) == 0 || i+1 < len(keys) && bytes.HasPrefix(keys[i+1], key) {
+			isPrefixKey++
+		}
+	}
+
+	return hasChild + len(keys) - isPrefixKey, hasChild, isPrefixKey
+}
+
+// nodeCount returns the number of nodes of the LOUDS-DENSE encoded tree built
+// from the given keys, which must be sorted and unique.
+//
+// The root node is always accounted for, even if there are no keys.
+func nodeCount(keys []louds.Key) int {
+	// Each node other than the root corresponds to a unique prefix p of
+	// length >= 1 such that some key is strictly longer than p.
+	// With sorted keys, all such prefixes of a key which it shares with its
+	// predecessor were already accounted for by said predecessor - unless
+	// the predecessor is itself a prefix of the key, in which case the
+	// node of the full predecessor is new.
+	nodes := 1
+
+	for i, key := range keys {
+		shared := 0
+		if i != 0 {
+			prev := keys[i-1]
+			differ, idx := louds.FirstDifferenceAt(key, prev)
+			if differ {
+				shared = idx
+			} else {
+				shared = len(key)
+			}
+
+			if shared == len(prev) && shared > 0 {
+				// Predecessor is a non-empty prefix of this
+				// key. (The empty key's node is the root,
+				// which is accounted for already.)
+				shared--
+			}
+		}
+
+		if newNodes := len(key) - 1 - shared; newNodes > 0 {
+			nodes += newNodes
+		}
+	}
+
+	return nodes
+}
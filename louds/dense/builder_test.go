@@ -198,3 +198,183 @@ func TestBuildMultiLevel(t *testing.T) {
 
 	_ = keys
 }
+
+func TestBuildInvalidKeys(t *testing.T) {
+	builder := NewBuilder(BUILDER_MEMORY_LIMIT)
+	err := builder.Build([]louds.Key{[]byte("b"), []byte("a")})
+	assert.ErrorIs(t, err, louds.ErrUnsortedInput)
+
+	builder = NewBuilder(BUILDER_MEMORY_LIMIT)
+	err = builder.Build([]louds.Key{[]byte("a"), []byte("a")})
+	assert.ErrorIs(t, err, louds.ErrDuplicateKey)
+}
+
+func TestBuildEmptyKey(t *testing.T) {
+	builder := NewBuilder(BUILDER_MEMORY_LIMIT)
+	assert.Nil(t, builder.Build([]louds.Key{[]byte("")}))
+
+	// Only the root node, which is a prefix key
+	expectedLabels := bitmap.New(256, 256)
+	expectedHasChild := bitmap.New(256, 256)
+	expectedIsPrefixKey := bitmap.New(1, 256)
+	assert.Nil(t, expectedIsPrefixKey.Set(0))
+
+	assert.True(t, expectedLabels.Equal(builder.Labels), "Expected Labels:\n%s\nGot:\n%s", expectedLabels, builder.Labels)
+	assert.True(t, expectedHasChild.Equal(builder.HasChild), "Expected HasChild:\n%s\nGot:\n%s", expectedHasChild, builder.HasChild)
+	assert.True(t, expectedIsPrefixKey.Equal(builder.IsPrefixKey), "Expected IsPrefixKey:\n%s\nGot:\n%s", expectedIsPrefixKey, builder.IsPrefixKey)
+}
+
+func TestBuildPrefixChain(t *testing.T) {
+	builder := NewBuilder(BUILDER_MEMORY_LIMIT)
+	keys := []louds.Key{[]byte(""), []byte("a"), []byte("ab"), []byte("abc")}
+	assert.Nil(t, builder.Build(keys))
+
+	// Root -a-> node 1 -b-> node 2 -c-> leaf
+	expectedLabels := bitmap.New(3*256, 3*256)
+	expectedHasChild := bitmap.New(3*256, 3*256)
+	expectedIsPrefixKey := bitmap.New(3, 256)
+
+	for _, bit := range []int{0*256 + 'a', 1*256 + 'b', 2*256 + 'c'} {
+		assert.Nil(t, expectedLabels.Set(bit))
+	}
+	for _, bit := range []int{0*256 + 'a', 1*256 + 'b'} {
+		assert.Nil(t, expectedHasChild.Set(bit))
+	}
+	for _, bit := range []int{0, 1, 2} {
+		assert.Nil(t, expectedIsPrefixKey.Set(bit))
+	}
+
+	assert.True(t, expectedLabels.Equal(builder.Labels), "Expected Labels:\n%s\nGot:\n%s", expectedLabels, builder.Labels)
+	assert.True(t, expectedHasChild.Equal(builder.HasChild), "Expected HasChild:\n%s\nGot:\n%s", expectedHasChild, builder.HasChild)
+	assert.True(t, expectedIsPrefixKey.Equal(builder.IsPrefixKey), "Expected IsPrefixKey:\n%s\nGot:\n%s", expectedIsPrefixKey, builder.IsPrefixKey)
+	assert.Equal(t, 3, nodeCount(keys))
+}
+
+func TestBuildMemoryLimitExceeded(t *testing.T) {
+	// The paper's keys need 8 nodes, so a limit of 7 nodes won't do.
+	builder := NewBuilder(7 * bitsPerNode)
+	err := builder.Build(keys)
+
+	var limitErr *louds.MemoryLimitError
+	assert.ErrorIs(t, err, louds.ErrMemoryLimitExceeded)
+	assert.ErrorAs(t, err, &limitErr)
+	assert.Equal(t, 8*bitsPerNode, limitErr.Required)
+	assert.Equal(t, 7*bitsPerNode, limitErr.Available)
+
+	// Whereas 8 nodes are just enough
+	builder = NewBuilder(8 * bitsPerNode)
+	assert.Nil(t, builder.Build(keys))
+}
+
+func TestBuildShrinksToFit(t *testing.T) {
+	builder := NewBuilder(BUILDER_MEMORY_LIMIT)
+	empty := bitmap.New(0, 0).SizeBytes()
+
+	// Only memory for the paper's 8 nodes is kept, regardless of the
+	// memory limit. On top of that comes the rank index, with one int per
+	// 8 uint64s.
+	assert.Nil(t, builder.Build(keys))
+	assert.Equal(t, empty+8*256/8+8*4, builder.Labels.SizeBytes())
+	assert.Equal(t, empty+8*256/8+8*4, builder.HasChild.SizeBytes())
+	assert.Equal(t, empty+8+8, builder.IsPrefixKey.SizeBytes())
+}
+
+func TestBuildRankIndex(t *testing.T) {
+	builder := NewBuilder(BUILDER_MEMORY_LIMIT)
+	assert.Nil(t, builder.Build(keys))
+
+	assert.True(t, builder.Labels.HasRankIndex())
+	assert.True(t, builder.HasChild.HasRankIndex())
+	assert.True(t, builder.IsPrefixKey.HasRankIndex())
+}
+
+func TestBuildAppendsNodes(t *testing.T) {
+	builder := NewBuilder(BUILDER_MEMORY_LIMIT)
+	assert.Nil(t, builder.Build(keys))
+
+	// Nodes are appended as a whole, so the bitmaps end right after the
+	// last node.
+	assert.Equal(t, 8*256, builder.Labels.End())
+	assert.Equal(t, 8*256, builder.HasChild.End())
+	assert.Equal(t, 8, builder.IsPrefixKey.End())
+
+	// Even if only the root exists
+	for _, keySet := range [][]louds.Key{{}, {[]byte{}}} {
+		builder := NewBuilder(BUILDER_MEMORY_LIMIT)
+		assert.Nil(t, builder.Build(keySet))
+
+		assert.Equal(t, 256, builder.Labels.End())
+		assert.Equal(t, 1, builder.IsPrefixKey.End())
+	}
+}
+
+func TestConcatBuilderOutput(t *testing.T) {
+	first := NewBuilder(BUILDER_MEMORY_LIMIT)
+	assert.Nil(t, first.Build(keys))
+
+	second := NewBuilder(BUILDER_MEMORY_LIMIT)
+	assert.Nil(t, second.Build(keys[5:]))
+
+	for _, pair := range [][2]*bitmap.Bitmap{
+		{first.Labels, second.Labels},
+		{first.HasChild, second.HasChild},
+		{first.IsPrefixKey, second.IsPrefixKey},
+	} {
+		out := bitmap.Concat(pair[0], pair[1])
+		assert.Equal(t, pair[0].End()+pair[1].End(), out.End())
+		assert.Equal(t, pair[0].PopCount()+pair[1].PopCount(), out.PopCount())
+
+		for i := 0; i < out.End(); i++ {
+			expected, _ := pair[0].Get(i)
+			if i >= pair[0].End() {
+				expected, _ = pair[1].Get(i - pair[0].End())
+			}
+
+			actual, err := out.Get(i)
+			assert.Nil(t, err)
+			assert.Equal(t, expected, actual, "Bit %d", i)
+		}
+	}
+}
+
+func TestNodeCount(t *testing.T) {
+	tests := []struct {
+		keys  []louds.Key
+		nodes int
+	}{
+		{[]louds.Key{}, 1},
+		{[]louds.Key{[]byte("a"), []byte("b")}, 1},
+		{[]louds.Key{[]byte("ai"), []byte("ao"), []byte("f"), []byte("fa"), []byte("fe")}, 3},
+		{[]louds.Key{[]byte("a"), []byte("ab"), []byte("abc"), []byte("abcd")}, 4},
+		{[]louds.Key{[]byte(""), []byte("a"), []byte("b")}, 1},
+		{[]louds.Key{[]byte(""), []byte("ab")}, 2},
+		{keys, 8},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.nodes, nodeCount(test.keys), "Keys: %s", test.keys)
+	}
+}
+
+func TestEncodedOnes(t *testing.T) {
+	keySets := [][]louds.Key{
+		{},
+		{[]byte("a"), []byte("b")},
+		{[]byte("ai"), []byte("ao"), []byte("f"), []byte("fa"), []byte("fe")},
+		{[]byte("a"), []byte("ab"), []byte("abc"), []byte("abcd")},
+		{[]byte(""), []byte("a"), []byte("b")},
+		{[]byte(""), []byte("ab")},
+		{[]byte("")},
+		keys,
+	}
+
+	for _, keys := range keySets {
+		builder := NewBuilder(BUILDER_MEMORY_LIMIT)
+		assert.Nil(t, builder.Build(keys))
+
+		labels, hasChild, isPrefixKey := EncodedOnes(keys)
+		assert.Equal(t, builder.Labels.PopCount(), labels, "Keys: %s", keys)
+		assert.Equal(t, builder.HasChild.PopCount(), hasChild, "Keys: %s", keys)
+		assert.Equal(t, builder.IsPrefixKey.PopCount(), isPrefixKey, "Keys: %s", keys)
+	}
+}
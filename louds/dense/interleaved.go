@@ -0,0 +1,339 @@
+package dense
+
+import (
+	"fmt"
+	"math/bits"
+	"unsafe"
+
+	"github.com/Lavode/surf/bitmap"
+	"github.com/Lavode/surf/bitops"
+)
+
+// cacheLineSize is the size in bytes of a CPU cache line on common platforms.
+const cacheLineSize = 64
+
+// nodeBlock holds the D-Labels and D-HasChild bits of a single node, along
+// with the number of bits set in either bitmap for all preceding nodes.
+//
+// Labels and HasChild fill the first cache line of the block, the ranks the
+// second one. The data needed to follow an edge thus lies within two adjacent
+// cache lines, rather than in three separate arrays. The D-Labels and
+// D-HasChild views still read it with separate calls, so this saves cache
+// misses, not loads.
+type nodeBlock struct {
+	labels   [4]uint64
+	hasChild [4]uint64
+	// labelsRank and hasChildRank are the number of set D-Labels and
+	// D-HasChild bits in all preceding nodes.
+	labelsRank   uint64
+	hasChildRank uint64
+	// Padding to a multiple of the cache line size, such that all blocks
+	// are aligned.
+	_ [6]uint64
+}
+
+// NodeBlockBits is the number of bits of memory the interleaved layout uses
+// per node, for the node's D-Labels and D-HasChild bits along with their
+// ranks and padding.
+const NodeBlockBits = 8 * int(unsafe.Sizeof(nodeBlock{}))
+
+// Interleaved is an alternative, cache-friendly layout of the D-Labels and
+// D-HasChild bitmaps of a LOUDS-DENSE encoded tree.
+//
+// Rather than storing the bitmaps in separate arrays, each node's 256 label
+// bits and 256 has-child bits are co-located in one cache-line-aligned block,
+// together with the precomputed rank of the node. Rank queries thus take
+// constant time, without any additional index.
+//
+// The bitmaps are accessed through the views returned by Labels and HasChild,
+// which implement bitmap.RankSelect. Interleaved is read-only.
+type Interleaved struct {
+	blocks []nodeBlock
+	// labelsOnes and hasChildOnes are the total number of set D-Labels and
+	// D-HasChild bits.
+	labelsOnes   int
+	hasChildOnes int
+}
+
+// NewInterleaved builds the interleaved layout of the given D-Labels and
+// D-HasChild bitmaps.
+//
+// An error is returned if the bitmaps differ in length, or their length is not
+// a multiple of 256.
+func NewInterleaved(labels, hasChild bitmap.RankSelect) (*Interleaved, error) {
+	if labels.Len() != hasChild.Len() || labels.Len()%256 != 0 {
+		return nil, fmt.Errorf(
+			"D-Labels and D-HasChild must be of equal length, a multiple of 256. Were %d and %d",
+			labels.Len(),
+			hasChild.Len(),
+		)
+	}
+
+	il := Interleaved{blocks: alignedBlocks(labels.Len() / 256)}
+
+	err := bitmap.ForEachOne(labels, func(bit int) bool {
+		il.blocks[bit/256].labels[bit%256/64] |= bitops.SingleOneMask(bit % 64)
+		il.labelsOnes++
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Error enumerating set bits of D-Labels: %v", err)
+	}
+
+	err = bitmap.ForEachOne(hasChild, func(bit int) bool {
+		il.blocks[bit/256].hasChild[bit%256/64] |= bitops.SingleOneMask(bit % 64)
+		il.hasChildOnes++
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Error enumerating set bits of D-HasChild: %v", err)
+	}
+
+	var labelsRank, hasChildRank uint64
+	for i := range il.blocks {
+		block := &il.blocks[i]
+		block.labelsRank = labelsRank
+		block.hasChildRank = hasChildRank
+
+		for w := 0; w < 4; w++ {
+			labelsRank += uint64(bits.OnesCount64(block.labels[w]))
+			hasChildRank += uint64(bits.OnesCount64(block.hasChild[w]))
+		}
+	}
+
+	return &il, nil
+}
+
+// alignedBlocks returns n zeroed blocks, starting at a cache line boundary.
+func alignedBlocks(n int) []nodeBlock {
+	// Go only guarantees the alignment of the block's fields, so we
+	// allocate a spare block, and skip the bytes up to the first cache
+	// line boundary.
+	buf := make([]nodeBlock, n+1)
+
+	start := unsafe.Pointer(&buf[0])
+	if skip := uintptr(start) % cacheLineSize; skip != 0 {
+		start = unsafe.Add(start, cacheLineSize-skip)
+	}
+
+	return unsafe.Slice((*nodeBlock)(start), n)
+}
+
+// Labels returns a view of the D-Labels bitmap.
+func (il *Interleaved) Labels() *InterleavedBitmap {
+	return &InterleavedBitmap{layout: il}
+}
+
+// HasChild returns a view of the D-HasChild bitmap.
+func (il *Interleaved) HasChild() *InterleavedBitmap {
+	return &InterleavedBitmap{layout: il, hasChild: true}
+}
+
+// SizeBits returns the number of bits used by the nodes' blocks, that is
+// NodeBlockBits per node.
+func (il *Interleaved) SizeBits() int {
+	return NodeBlockBits * len(il.blocks)
+}
+
+// SizeBytes returns the number of bytes of memory occupied by the layout.
+func (il *Interleaved) SizeBytes() int {
+	return int(unsafe.Sizeof(*il)) + int(unsafe.Sizeof(nodeBlock{}))*(len(il.blocks)+1)
+}
+
+// InterleavedBitmap is a view of either the D-Labels or the D-HasChild bitmap
+// of an Interleaved layout.
+//
+// It implements bitmap.RankSelect, as well as NextOne and CountOnes.
+type InterleavedBitmap struct {
+	layout *Interleaved
+	// hasChild is whether this is a view of D-HasChild, rather than
+	// D-Labels.
+	hasChild bool
+}
+
+// Layout returns the layout this is a view of.
+func (view *InterleavedBitmap) Layout() *Interleaved {
+	return view.layout
+}
+
+// Len returns the number of bits in the bitmap.
+func (view *InterleavedBitmap) Len() int {
+	return 256 * len(view.layout.blocks)
+}
+
+// Get retrieves the value at a given index.
+//
+// While the returned value is a byte, it will always be either 0 or 1.
+//
+// An error is returned if the index is invalid.
+func (view *InterleavedBitmap) Get(bit int) (byte, error) {
+	if bit < 0 || bit >= view.Len() {
+		return 0, fmt.Errorf("Invalid index %d. Must be in range [0, %d]", bit, view.Len()-1)
+	}
+
+	return byte(view.word(bit/64) >> (63 - bit%64) & 1), nil
+}
+
+// Rank returns the number of bits with value val, up to and including
+// position idx.
+//
+// It takes constant time, as the rank of each node is stored in its block.
+//
+// An error is returned if the index is outside the range of the bitmap, or if
+// val is neither 0 nor 1.
+func (view *InterleavedBitmap) Rank(val, idx int) (int, error) {
+	if idx < 0 || idx > view.Len()-1 {
+		return 0, fmt.Errorf("Index must be in range [%d, %d]. Was %d]", 0, view.Len()-1, idx)
+	}
+
+	if !(val == 0 || val == 1) {
+		return 0, fmt.Errorf("Val must be one of 0, 1. Was %d", val)
+	}
+
+	ones := view.onesBefore(idx + 1)
+	if val == 1 {
+		return ones, nil
+	}
+	return idx + 1 - ones, nil
+}
+
+// Select returns the index of the nth bit of value val.
+//
+// The node holding the bit is found by binary search over the ranks of the
+// nodes, the bit within it with bitops.Select64.
+//
+// An error is returned if there is no nth bit of value val in the bitmap, or
+// if val is neither 0 nor 1.
+func (view *InterleavedBitmap) Select(val, nth int) (int, error) {
+	if !(val == 0 || val == 1) {
+		return 0, fmt.Errorf("Val must be one of 0, 1. Was %d", val)
+	}
+
+	total := view.ones()
+	if val == 0 {
+		total = view.Len() - total
+	}
+	if nth <= 0 || nth > total {
+		return 0, fmt.Errorf("Bitmap only contained %d bits of value %d, cannot select bit %d", total, val, nth)
+	}
+
+	// Number of bits of value val preceding the given node
+	before := func(node int) int {
+		ones := view.rank(&view.layout.blocks[node])
+		if val == 1 {
+			return ones
+		}
+		return 256*node - ones
+	}
+
+	// Last node preceded by fewer than nth bits
+	low, high := 0, len(view.layout.blocks)-1
+	for low < high {
+		mid := low + (high-low+1)/2
+		if before(mid) < nth {
+			low = mid
+		} else {
+			high = mid - 1
+		}
+	}
+
+	count := before(low)
+	words := view.words(&view.layout.blocks[low])
+	for w, word := range words {
+		if val == 0 {
+			word = ^word
+		}
+
+		cnt := bits.OnesCount64(word)
+		if count+cnt >= nth {
+			return 256*low + 64*w + bitops.Select64(word, nth-count), nil
+		}
+		count += cnt
+	}
+
+	// The ranks stored in the blocks guarantee that the node holds the bit.
+	return 0, fmt.Errorf("Node %d does not hold bit %d of value %d", low, nth, val)
+}
+
+// NextOne returns the index of the first 1-bit at or after position from, as
+// bitmap.Bitmap.NextOne does.
+func (view *InterleavedBitmap) NextOne(from int) (int, bool) {
+	if from < 0 {
+		from = 0
+	}
+
+	for w := from / 64; w < view.Len()/64; w++ {
+		word := view.word(w)
+		if w == from/64 {
+			word = bitops.LastBits(64-from%64, word)
+		}
+
+		if word != 0 {
+			return 64*w + bits.LeadingZeros64(word), true
+		}
+	}
+
+	return 0, false
+}
+
+// CountOnes returns the number of 1-bits in the range [from, to), as
+// bitmap.Bitmap.CountOnes does.
+//
+// It takes constant time, as the rank of each node is stored in its block.
+func (view *InterleavedBitmap) CountOnes(from, to int) (int, error) {
+	if from < 0 || to > view.Len() || from > to {
+		return 0, fmt.Errorf("Range [%d, %d) must be within [0, %d)", from, to, view.Len())
+	}
+
+	return view.onesBefore(to) - view.onesBefore(from), nil
+}
+
+// onesBefore returns the number of 1-bits preceding position pos, which must
+// be in [0, Len()].
+func (view *InterleavedBitmap) onesBefore(pos int) int {
+	if pos == view.Len() {
+		return view.ones()
+	}
+
+	block := &view.layout.blocks[pos/256]
+	words := view.words(block)
+
+	cnt := view.rank(block)
+	for w := 0; w < pos%256/64; w++ {
+		cnt += bits.OnesCount64(words[w])
+	}
+	if offset := pos % 64; offset > 0 {
+		cnt += bitops.Rank64(words[pos%256/64], offset-1)
+	}
+
+	return cnt
+}
+
+// word returns the i-th uint64 of the bitmap.
+func (view *InterleavedBitmap) word(i int) uint64 {
+	return view.words(&view.layout.blocks[i/4])[i%4]
+}
+
+// words returns the bitmap's words within the given block.
+func (view *InterleavedBitmap) words(block *nodeBlock) *[4]uint64 {
+	if view.hasChild {
+		return &block.hasChild
+	}
+	return &block.labels
+}
+
+// rank returns the number of the bitmap's 1-bits preceding the given block.
+func (view *InterleavedBitmap) rank(block *nodeBlock) int {
+	if view.hasChild {
+		return int(block.hasChildRank)
+	}
+	return int(block.labelsRank)
+}
+
+// ones returns the total number of the bitmap's 1-bits.
+func (view *InterleavedBitmap) ones() int {
+	if view.hasChild {
+		return view.layout.hasChildOnes
+	}
+	return view.layout.labelsOnes
+}
@@ -0,0 +1,107 @@
+package dense
+
+import (
+	"math/rand"
+	"sort"
+	"testing"
+	"unsafe"
+
+	"github.com/Lavode/surf/bitmap"
+	"github.com/Lavode/surf/louds"
+	"github.com/stretchr/testify/assert"
+)
+
+// checkInterleaved compares all queries of view against those of the plain
+// bitmap bm.
+func checkInterleaved(t *testing.T, bm *bitmap.Bitmap, view *InterleavedBitmap) {
+	assert.Equal(t, bm.Len(), view.Len())
+
+	counts := [2]int{}
+	for i := 0; i < bm.Len(); i++ {
+		expected, _ := bm.Get(i)
+		actual, err := view.Get(i)
+		assert.Nil(t, err)
+		assert.Equal(t, expected, actual, "Get(%d)", i)
+		counts[expected]++
+
+		for val := 0; val <= 1; val++ {
+			expectedRank, _ := bm.Rank(val, i)
+			actualRank, err := view.Rank(val, i)
+			assert.Nil(t, err)
+			assert.Equal(t, expectedRank, actualRank, "Rank(%d, %d)", val, i)
+		}
+
+		selected, err := view.Select(int(expected), counts[expected])
+		assert.Nil(t, err)
+		assert.Equal(t, i, selected, "Select(%d, %d)", expected, counts[expected])
+
+		expectedNext, expectedFound := bm.NextOne(i)
+		actualNext, actualFound := view.NextOne(i)
+		assert.Equal(t, expectedFound, actualFound, "NextOne(%d)", i)
+		assert.Equal(t, expectedNext, actualNext, "NextOne(%d)", i)
+	}
+
+	for val, cnt := range counts {
+		_, err := view.Select(val, cnt+1)
+		assert.Error(t, err)
+	}
+
+	for from := 0; from <= bm.Len(); from += 37 {
+		for to := from; to <= bm.Len(); to += 41 {
+			expected, _ := bm.CountOnes(from, to)
+			actual, err := view.CountOnes(from, to)
+			assert.Nil(t, err)
+			assert.Equal(t, expected, actual, "CountOnes(%d, %d)", from, to)
+		}
+	}
+}
+
+func TestInterleaved(t *testing.T) {
+	rng := rand.New(rand.NewSource(42))
+	random := make([]louds.Key, 0)
+	seen := make(map[string]bool)
+	for len(random) < 50 {
+		key := make([]byte, 1+rng.Intn(4))
+		for i := range key {
+			key[i] = byte('a' + rng.Intn(4))
+		}
+		if !seen[string(key)] {
+			seen[string(key)] = true
+			random = append(random, key)
+		}
+	}
+	sort.Slice(random, func(i, j int) bool {
+		return random[i].Less(random[j])
+	})
+	random = louds.Truncate(random)
+
+	for _, keySet := range [][]louds.Key{keys, random, {}} {
+		builder := NewBuilder(BUILDER_MEMORY_LIMIT)
+		assert.Nil(t, builder.Build(keySet))
+
+		il, err := NewInterleaved(builder.Labels, builder.HasChild)
+		assert.Nil(t, err)
+
+		checkInterleaved(t, builder.Labels, il.Labels())
+		checkInterleaved(t, builder.HasChild, il.HasChild())
+		assert.Equal(t, 1024*builder.Labels.Len()/256, il.SizeBits())
+	}
+}
+
+func TestInterleavedAligned(t *testing.T) {
+	assert.Equal(t, uintptr(2*cacheLineSize), unsafe.Sizeof(nodeBlock{}))
+
+	for n := 1; n < 20; n++ {
+		blocks := alignedBlocks(n)
+		assert.Equal(t, n, len(blocks))
+		assert.Equal(t, uintptr(0), uintptr(unsafe.Pointer(&blocks[0]))%cacheLineSize)
+	}
+}
+
+func TestNewInterleavedInvalid(t *testing.T) {
+	_, err := NewInterleaved(bitmap.New(256, 256), bitmap.New(512, 512))
+	assert.NotNil(t, err)
+
+	_, err = NewInterleaved(bitmap.New(320, 320), bitmap.New(320, 320))
+	assert.NotNil(t, err)
+}
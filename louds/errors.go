@@ -0,0 +1,72 @@
+package louds
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrUnsortedInput indicates that the keys passed to a builder were not sorted
+// in lexicographic order.
+var ErrUnsortedInput = errors.New("Keys are not sorted")
+
+// ErrDuplicateKey indicates that the same key was passed to a builder more
+// than once.
+var ErrDuplicateKey = errors.New("Duplicate key")
+
+// ErrMemoryLimitExceeded indicates that encoding a set of keys would require
+// more memory than the configured limit allows.
+//
+// Errors wrapping it are of type *MemoryLimitError, which carries the details.
+var ErrMemoryLimitExceeded = errors.New("Memory limit exceeded")
+
+// MemoryLimitError is returned if encoding a set of keys would require more
+// bits than are available.
+//
+// It wraps ErrMemoryLimitExceeded, so can be checked for using errors.Is.
+type MemoryLimitError struct {
+	// Required is the number of bits which would be needed to encode the
+	// keys.
+	Required int
+	// Available is the number of bits the memory limit allows for.
+	Available int
+}
+
+func (err *MemoryLimitError) Error() string {
+	return fmt.Sprintf(
+		"%v: %d bits required, but only %d bits available",
+		ErrMemoryLimitExceeded,
+		err.Required,
+		err.Available,
+	)
+}
+
+// Unwrap returns ErrMemoryLimitExceeded.
+func (err *MemoryLimitError) Unwrap() error {
+	return ErrMemoryLimitExceeded
+}
+
+// ValidateKeys checks whether the given keys are suitable to be encoded in a
+// LOUDS-encoded FST.
+//
+// That is, they must be sorted in strictly increasing lexicographic order.
+//
+// An error wrapping ErrDuplicateKey or ErrUnsortedInput is returned on the
+// first key violating this constraint.
+func ValidateKeys(keys []Key) error {
+	for i, key := range keys {
+		if i == 0 {
+			continue
+		}
+
+		prev := keys[i-1]
+		if !prev.Less(key) {
+			if !key.Less(prev) {
+				return fmt.Errorf("%w: %x at index %d", ErrDuplicateKey, key, i)
+			}
+
+			return fmt.Errorf("%w: %x at index %d is less than its predecessor %x", ErrUnsortedInput, key, i, prev)
+		}
+	}
+
+	return nil
+}
@@ -132,3 +132,25 @@ func TestFirstDifferenceAt(t *testing.T) {
 	differs, idx = FirstDifferenceAt(a, b)
 	assert.False(t, differs)
 }
+
+func TestValidateKeys(t *testing.T) {
+	assert.Nil(t, ValidateKeys([]Key{}))
+	assert.Nil(t, ValidateKeys([]Key{Key("a"), Key("ab"), Key("b")}))
+	assert.Nil(t, ValidateKeys([]Key{Key(""), Key("a")}))
+
+	err := ValidateKeys([]Key{Key("a"), Key("")})
+	assert.ErrorIs(t, err, ErrUnsortedInput)
+
+	err = ValidateKeys([]Key{Key("a"), Key("b"), Key("b")})
+	assert.ErrorIs(t, err, ErrDuplicateKey)
+
+	err = ValidateKeys([]Key{Key("ab"), Key("a")})
+	assert.ErrorIs(t, err, ErrUnsortedInput)
+}
+
+func TestMemoryLimitError(t *testing.T) {
+	var err error = &MemoryLimitError{Required: 1026, Available: 513}
+
+	assert.ErrorIs(t, err, ErrMemoryLimitExceeded)
+	assert.Equal(t, "Memory limit exceeded: 1026 bits required, but only 513 bits available", err.Error())
+}
@@ -27,6 +27,35 @@ func (stack *Stack[T]) Pop() T {
 	return x
 }
 
+// Len returns the number of elements in the stack.
+func (stack *Stack[T]) Len() int {
+	return len(stack.data)
+}
+
+// At returns the i-th element of the stack, counting from the bottom.
+//
+// Accessing an index outside of [0, Len() - 1] will cause a panic.
+func (stack *Stack[T]) At(i int) T {
+	return stack.data[i]
+}
+
+// View returns the data contained in the stack, with the most recently added
+// item in the last position.
+//
+// Unlike Data, it does not copy the data. The returned slice is only valid
+// until the stack is next modified.
+func (stack *Stack[T]) View() []T {
+	return stack.data
+}
+
+// Reset removes all elements from the stack.
+//
+// The memory backing the stack is retained, such that it can be reused
+// without allocating.
+func (stack *Stack[T]) Reset() {
+	stack.data = stack.data[:0]
+}
+
 // Data returns a (shallow) copy of the data contained in the stack, with the
 // most recently added item in the last position.
 func (stack *Stack[T]) Data() []T {
@@ -21,6 +21,20 @@ func TestPushPop(t *testing.T) {
 	assert.Equal(t, byte(3), stack.Pop())
 }
 
+func TestLenAndAt(t *testing.T) {
+	stack := Stack[int]{}
+	assert.Equal(t, 0, stack.Len())
+
+	stack.Push(3)
+	stack.Push(17)
+	assert.Equal(t, 2, stack.Len())
+	assert.Equal(t, 3, stack.At(0))
+	assert.Equal(t, 17, stack.At(1))
+
+	stack.Pop()
+	assert.Equal(t, 1, stack.Len())
+}
+
 func TestData(t *testing.T) {
 	stack := Stack[string]{}
 
@@ -31,3 +45,22 @@ func TestData(t *testing.T) {
 	expected := []string{"Hello", "World", "From the stack"}
 	assert.Equal(t, expected, stack.Data())
 }
+
+func TestViewAndReset(t *testing.T) {
+	stack := Stack[int]{}
+
+	stack.Push(1)
+	stack.Push(2)
+	assert.Equal(t, []int{1, 2}, stack.View())
+
+	stack.Reset()
+	assert.Equal(t, 0, stack.Len())
+	assert.Equal(t, []int{}, stack.View())
+
+	allocs := testing.AllocsPerRun(10, func() {
+		stack.Push(3)
+		stack.Push(4)
+		stack.Reset()
+	})
+	assert.Equal(t, 0.0, allocs)
+}
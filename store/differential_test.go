@@ -0,0 +1,181 @@
+package store
+
+import (
+	"bytes"
+	"errors"
+	"sort"
+	"testing"
+
+	"github.com/Lavode/surf/louds"
+)
+
+// maxOracleQueries is the maximum number of queries checked against the oracle
+// per fuzzing input.
+const maxOracleQueries = 32
+
+// parseKeys splits fuzzer-generated data into unique keys.
+//
+// Each key is encoded as a length byte, followed by the key's bytes. The
+// length is taken modulo maxLength, and incremented by minLength.
+func parseKeys(data []byte, minLength, maxLength int) [][]byte {
+	seen := make(map[string]bool)
+	keys := make([][]byte, 0)
+
+	for i := 0; i < len(data); {
+		n := minLength + int(data[i])%maxLength
+		i++
+
+		end := i + n
+		if end > len(data) {
+			end = len(data)
+		}
+		key := data[i:end]
+		i = end
+
+		if len(key) < minLength || seen[string(key)] {
+			continue
+		}
+		seen[string(key)] = true
+		keys = append(keys, key)
+	}
+
+	return keys
+}
+
+// oracleCount returns the number of sorted keys in [low, high].
+func oracleCount(keys [][]byte, low, high []byte) int {
+	start := sort.Search(len(keys), func(i int) bool {
+		return bytes.Compare(keys[i], low) >= 0
+	})
+	end := sort.Search(len(keys), func(i int) bool {
+		return bytes.Compare(keys[i], high) > 0
+	})
+
+	if end < start {
+		return 0
+	}
+	return end - start
+}
+
+// checkAgainstOracle builds a SuRF store from keys, and checks its answers to
+// the given queries against a sorted slice of the keys.
+func checkAgainstOracle(t *testing.T, keys, queries [][]byte) {
+	surf, err := New(keys, SURFOptions{})
+	if err != nil {
+		t.Fatalf("Error creating SuRF store: %v", err)
+	}
+
+	sorted := make([][]byte, len(keys))
+	copy(sorted, keys)
+	sort.Slice(sorted, func(i, j int) bool {
+		return bytes.Compare(sorted[i], sorted[j]) < 0
+	})
+
+	// No false negatives for any key, or any of its prefixes
+	for _, key := range keys {
+		exists, err := surf.Lookup(key)
+		if err != nil || !exists {
+			t.Errorf("Lookup(%x) = %t, %v; expected true", key, exists, err)
+		}
+
+		exists, err = surf.RangeLookup(key, key)
+		if err != nil || !exists {
+			t.Errorf("RangeLookup(%x, %x) = %t, %v; expected true", key, key, exists, err)
+		}
+
+		for i := 0; i <= len(key); i++ {
+			hasPrefix, err := surf.HasPrefix(key[:i])
+			if err != nil || !hasPrefix {
+				t.Errorf("HasPrefix(%x) = %t, %v; expected true", key[:i], hasPrefix, err)
+			}
+		}
+	}
+
+	// Ranges spanned by queries: No false negatives, and counts at most two
+	// too high.
+	for i, a := range queries {
+		for _, b := range queries[i:] {
+			low, high := a, b
+			if bytes.Compare(low, high) > 0 {
+				low, high = high, low
+			}
+
+			expected := oracleCount(sorted, low, high)
+
+			exists, err := surf.RangeLookup(low, high)
+			if err != nil || (expected > 0 && !exists) {
+				t.Errorf("RangeLookup(%x, %x) = %t, %v; expected true", low, high, exists, err)
+			}
+
+			count, err := surf.Count(low, high)
+			if err != nil || count < expected || count > expected+2 {
+				t.Errorf("Count(%x, %x) = %d, %v; expected in [%d, %d]", low, high, count, err, expected, expected+2)
+			}
+		}
+	}
+
+	// Batch lookups agree with individual ones
+	out := make([]bool, len(queries))
+	err = surf.LookupBatch(queries, out)
+	if err != nil {
+		t.Errorf("LookupBatch() = %v", err)
+	}
+	for i, query := range queries {
+		exists, err := surf.Lookup(query)
+		if err != nil || exists != out[i] {
+			t.Errorf("Lookup(%x) = %t, %v; LookupBatch yielded %t", query, exists, err, out[i])
+		}
+	}
+
+	// Iteration yields the truncated keys in order
+	truncated := make([]louds.Key, len(sorted))
+	for i, key := range sorted {
+		truncated[i] = louds.Key(key)
+	}
+	truncated = louds.Truncate(truncated)
+
+	it := Iterator{
+		Labels:      surf.DenseLabels,
+		HasChild:    surf.DenseHasChild,
+		IsPrefixKey: surf.DenseIsPrefixKey,
+	}
+	for _, expected := range truncated {
+		key, err := it.Next()
+		if err != nil || !bytes.Equal(expected, key) {
+			t.Fatalf("Next() = %x, %v; expected %x", key, err, expected)
+		}
+	}
+	key, err := it.Next()
+	if !errors.Is(err, ErrEndOfTrie) {
+		t.Errorf("Next() = %x, %v; expected end of trie", key, err)
+	}
+}
+
+func FuzzAgainstOracle(f *testing.F) {
+	f.Add([]byte("\x01f\x07farther\x03fas\x06fasten\x03fat\x06splice"), []byte("\x02fa\x01a\x04fasz\x01z"))
+	f.Add([]byte("\x01a\x02ab\x03abc\x04abcd"), []byte("\x01a\x02aa\x03abd"))
+	f.Add([]byte("\x00\x01a\x02a\x00\x03a\x00\x00"), []byte("\x00\x01\x00\x02a\xff"))
+	f.Add([]byte("\x02\x00\x01\x03\x00\x01\x02\x01\x42\x04\xff\x42\x70\x71"), []byte("\x01\x00\x01\xff"))
+
+	f.Fuzz(func(t *testing.T, keyData, queryData []byte) {
+		keys := parseKeys(keyData, 0, 8)
+		queries := parseKeys(queryData, 0, 8)
+
+		// Queries close to the keys are the most likely to trip up
+		// the trie.
+		for _, key := range keys {
+			queries = append(queries, key)
+			if len(key) > 0 {
+				queries = append(queries, key[:len(key)-1])
+			}
+		}
+
+		// Each pair of queries is checked as a range, so we must keep
+		// their number in check.
+		if len(queries) > maxOracleQueries {
+			queries = queries[:maxOracleQueries]
+		}
+
+		checkAgainstOracle(t, keys, queries)
+	})
+}
@@ -0,0 +1,114 @@
+package store
+
+import (
+	"fmt"
+
+	"github.com/Lavode/surf/bitmap"
+	"github.com/Lavode/surf/louds/dense"
+)
+
+// BitmapEncoding defines how a bitmap of a SuRF store is represented in
+// memory, trading space for query speed.
+type BitmapEncoding int
+
+const (
+	// EncodingPlain stores all bits verbatim, as a bitmap.Bitmap. It is
+	// the fastest encoding.
+	EncodingPlain BitmapEncoding = iota
+	// EncodingEliasFano stores the positions of 1-bits with Elias-Fano
+	// coding, as a bitmap.EliasFano. It is much smaller for sparse
+	// bitmaps, but slower to query.
+	EncodingEliasFano
+)
+
+func (enc BitmapEncoding) String() string {
+	switch enc {
+	case EncodingPlain:
+		return "Plain"
+	case EncodingEliasFano:
+		return "EliasFano"
+	default:
+		return fmt.Sprintf("BitmapEncoding(%d)", int(enc))
+	}
+}
+
+// valid returns whether enc is a known encoding.
+func (enc BitmapEncoding) valid() bool {
+	return enc == EncodingPlain || enc == EncodingEliasFano
+}
+
+// encode returns bm in this encoding.
+func (enc BitmapEncoding) encode(bm *bitmap.Bitmap) bitmap.RankSelect {
+	switch enc {
+	case EncodingEliasFano:
+		// Enumerating the 1-bits of a Bitmap cannot fail.
+		ef, _ := bitmap.NewEliasFano(bm)
+		return ef
+	default:
+		return bm
+	}
+}
+
+// sizeBits returns the number of bits a bitmap of the given number of bits,
+// ones of which are set, needs in this encoding.
+func (enc BitmapEncoding) sizeBits(bits, ones int) int {
+	switch enc {
+	case EncodingEliasFano:
+		// The bitmap is encoded as stored, padded to a multiple of 64
+		// bits.
+		return bitmap.EliasFanoSizeBits(64*((bits+63)/64), ones)
+	default:
+		return bits
+	}
+}
+
+// encodingOf returns the encoding of rs.
+func encodingOf(rs bitmap.RankSelect) BitmapEncoding {
+	if _, ok := rs.(*bitmap.EliasFano); ok {
+		return EncodingEliasFano
+	}
+
+	return EncodingPlain
+}
+
+// DenseLayout defines how the D-Labels and D-HasChild bitmaps of a SuRF store
+// are laid out in memory relative to each other.
+type DenseLayout int
+
+const (
+	// LayoutSeparate stores D-Labels and D-HasChild as separate bitmaps,
+	// each in the encoding configured for it.
+	LayoutSeparate DenseLayout = iota
+	// LayoutInterleaved co-locates each node's D-Labels and D-HasChild
+	// bits, along with its precomputed rank, in one cache-line-aligned
+	// block, as a dense.Interleaved. The data needed to follow an edge
+	// thus lies in a single block, rather than in three separate arrays,
+	// though it is still read with one access per bitmap. Each block takes
+	// dense.NodeBlockBits, twice the size of plain, separate bitmaps.
+	LayoutInterleaved
+)
+
+func (layout DenseLayout) String() string {
+	switch layout {
+	case LayoutSeparate:
+		return "Separate"
+	case LayoutInterleaved:
+		return "Interleaved"
+	default:
+		return fmt.Sprintf("DenseLayout(%d)", int(layout))
+	}
+}
+
+// valid returns whether layout is a known layout.
+func (layout DenseLayout) valid() bool {
+	return layout == LayoutSeparate || layout == LayoutInterleaved
+}
+
+// layoutOf returns the layout of the D-Labels bitmap labels.
+func layoutOf(labels bitmap.RankSelect) DenseLayout {
+	if _, ok := labels.(*dense.InterleavedBitmap); ok {
+		return LayoutInterleaved
+	}
+
+	return LayoutSeparate
+}
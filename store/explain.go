@@ -0,0 +1,196 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Termination describes where the traversal of a lookup through the trie
+// ended.
+type Termination int
+
+const (
+	// TerminationMissingEdge indicates that the current node lacked the
+	// edge for the next byte of the key, so the key does not exist.
+	TerminationMissingEdge Termination = iota
+	// TerminationLeaf indicates that the edge for a byte of the key led to
+	// a leaf. The stored key ending there shares all bytes up to and
+	// including this one with the key, so the key is taken to exist.
+	TerminationLeaf
+	// TerminationPrefixKey indicates that all bytes of the key were
+	// traversed, and a stored key ends at the node reached, as its
+	// D-IsPrefixKey bit is set.
+	TerminationPrefixKey
+	// TerminationNotPrefixKey indicates that all bytes of the key were
+	// traversed, but no stored key ends at the node reached.
+	TerminationNotPrefixKey
+)
+
+func (termination Termination) String() string {
+	switch termination {
+	case TerminationMissingEdge:
+		return "MissingEdge"
+	case TerminationLeaf:
+		return "Leaf"
+	case TerminationPrefixKey:
+		return "PrefixKey"
+	case TerminationNotPrefixKey:
+		return "NotPrefixKey"
+	default:
+		return fmt.Sprintf("Termination(%d)", int(termination))
+	}
+}
+
+// ExplainedLevel is the trace of one level of a lookup, i.e. of following the
+// edge for one byte of the key.
+type ExplainedLevel struct {
+	// Node is the level-order index of the node the edge was looked up
+	// in.
+	Node int
+	// Edge is the byte of the key, and thus the value of the edge looked
+	// up.
+	Edge byte
+	// HasLabel is whether the edge's D-Labels bit is set, i.e. whether
+	// the edge exists.
+	HasLabel bool
+	// HasChild is whether the edge's D-HasChild bit is set, i.e. whether
+	// it leads to a node rather than a leaf. It is only checked if the
+	// edge exists.
+	HasChild bool
+	// Rank is the rank_1 of D-HasChild up to and including the edge,
+	// which is the index of the node the edge leads to. It is -1 if the
+	// edge does not lead to a node, as no rank is computed then.
+	Rank int
+}
+
+// Explanation is the trace of a lookup through the trie, as returned by
+// Explain.
+type Explanation struct {
+	// Key is the key which was looked up.
+	Key []byte
+	// Levels holds the trace of each level visited, one per byte of the
+	// key which was looked up.
+	Levels []ExplainedLevel
+	// Termination is where the traversal ended.
+	Termination Termination
+	// Node is the level-order index of the node the traversal ended at.
+	// For TerminationPrefixKey and TerminationNotPrefixKey, it is the
+	// node whose D-IsPrefixKey bit decided the result.
+	Node int
+	// SuffixCheck is the kind of suffix which was compared to decide the
+	// result.
+	//
+	// SuRF does not store suffixes yet, whichever its HashBits and
+	// RealBits, so this is always SuffixNone, and the result is decided
+	// by the trie alone.
+	SuffixCheck SuffixMode
+	// Exists is the result of the lookup, as returned by Lookup.
+	Exists bool
+}
+
+// Explain looks up the given key as Lookup does, returning a trace of the
+// lookup's path through the trie.
+//
+// This allows to understand surprising results, e.g. which stored key caused
+// a false positive. Unlike Lookup, it allocates, so is not meant to be used on
+// hot paths.
+func (surf *SURF) Explain(key []byte) (*Explanation, error) {
+	explanation := Explanation{
+		Key:         key,
+		Levels:      make([]ExplainedLevel, 0, len(key)),
+		SuffixCheck: SuffixNone,
+	}
+
+	it := Iterator{
+		Labels:      surf.DenseLabels,
+		HasChild:    surf.DenseHasChild,
+		IsPrefixKey: surf.DenseIsPrefixKey,
+	}
+
+	for _, keyByte := range key {
+		level := ExplainedLevel{Node: it.NodeIndex, Edge: keyByte, Rank: -1}
+
+		// GoToChild only tells us where it ended up, so we check the
+		// bits it was based on ourselves.
+		offset := 256*it.NodeIndex + int(keyByte)
+		hasLabel, err := surf.DenseLabels.Get(offset)
+		if err != nil {
+			return nil, fmt.Errorf("Error accessing bit %d of D-Labels: %v", offset, err)
+		}
+		level.HasLabel = hasLabel == 1
+
+		if level.HasLabel {
+			hasChild, err := surf.DenseHasChild.Get(offset)
+			if err != nil {
+				return nil, fmt.Errorf("Error accessing bit %d of D-HasChild: %v", offset, err)
+			}
+			level.HasChild = hasChild == 1
+		}
+
+		err = it.GoToChild(keyByte)
+		if err == nil {
+			level.Rank = it.NodeIndex
+		}
+		explanation.Levels = append(explanation.Levels, level)
+
+		if errors.Is(err, ErrNoSuchEdge) {
+			explanation.Termination = TerminationMissingEdge
+			explanation.Node = it.NodeIndex
+			return &explanation, nil
+		} else if errors.Is(err, ErrIsLeaf) {
+			explanation.Termination = TerminationLeaf
+			explanation.Node = it.NodeIndex
+			explanation.Exists = true
+			return &explanation, nil
+		} else if err != nil {
+			return nil, err
+		}
+	}
+
+	isPrefixKey, err := surf.DenseIsPrefixKey.Get(it.NodeIndex)
+	if err != nil {
+		return nil, fmt.Errorf("Error accessing bit %d in D-IsPrefixKey: %v", it.NodeIndex, err)
+	}
+
+	explanation.Node = it.NodeIndex
+	explanation.Exists = isPrefixKey == 1
+	if explanation.Exists {
+		explanation.Termination = TerminationPrefixKey
+	} else {
+		explanation.Termination = TerminationNotPrefixKey
+	}
+
+	return &explanation, nil
+}
+
+func (explanation Explanation) String() string {
+	var sb strings.Builder
+
+	fmt.Fprintf(&sb, "Lookup of %q: exists = %t\n", explanation.Key, explanation.Exists)
+	for depth, level := range explanation.Levels {
+		fmt.Fprintf(
+			&sb,
+			"  [%d] node %d, edge %q (0x%02x): label = %t, has child = %t",
+			depth,
+			level.Node,
+			level.Edge,
+			level.Edge,
+			level.HasLabel,
+			level.HasChild,
+		)
+		if level.Rank >= 0 {
+			fmt.Fprintf(&sb, ", rank = %d", level.Rank)
+		}
+		sb.WriteString("\n")
+	}
+	fmt.Fprintf(&sb, "  ended at node %d: %v\n", explanation.Node, explanation.Termination)
+
+	if explanation.SuffixCheck == SuffixNone {
+		sb.WriteString("  suffix check: none, as suffixes are not stored\n")
+	} else {
+		fmt.Fprintf(&sb, "  suffix check: %v\n", explanation.SuffixCheck)
+	}
+
+	return sb.String()
+}
@@ -0,0 +1,91 @@
+package store
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExplain(t *testing.T) {
+	surf := newPaperSURF(t)
+
+	// Path of "fas", through nodes 0, 1 and 3 to node 6
+	path := []ExplainedLevel{
+		{Node: 0, Edge: 'f', HasLabel: true, HasChild: true, Rank: 1},
+		{Node: 1, Edge: 'a', HasLabel: true, HasChild: true, Rank: 3},
+		{Node: 3, Edge: 's', HasLabel: true, HasChild: true, Rank: 6},
+	}
+
+	tests := []struct {
+		key         string
+		levels      []ExplainedLevel
+		termination Termination
+		node        int
+		exists      bool
+	}{
+		{"fas", path, TerminationPrefixKey, 6, true},
+		{"fa", path[:2], TerminationNotPrefixKey, 3, false},
+		{
+			"fastest",
+			append(path[:3:3], ExplainedLevel{Node: 6, Edge: 't', HasLabel: true, HasChild: false, Rank: -1}),
+			TerminationLeaf,
+			6,
+			true,
+		},
+		{
+			"fasz",
+			append(path[:3:3], ExplainedLevel{Node: 6, Edge: 'z', Rank: -1}),
+			TerminationMissingEdge,
+			6,
+			false,
+		},
+		{"g", []ExplainedLevel{{Node: 0, Edge: 'g', Rank: -1}}, TerminationMissingEdge, 0, false},
+		{"", []ExplainedLevel{}, TerminationNotPrefixKey, 0, false},
+	}
+
+	for _, test := range tests {
+		explanation, err := surf.Explain([]byte(test.key))
+		if !assert.Nil(t, err) {
+			continue
+		}
+
+		assert.Equal(t, []byte(test.key), explanation.Key)
+		assert.Equal(t, test.levels, explanation.Levels, "Levels of %q", test.key)
+		assert.Equal(t, test.termination, explanation.Termination, "Termination of %q", test.key)
+		assert.Equal(t, test.node, explanation.Node, "Node of %q", test.key)
+		assert.Equal(t, test.exists, explanation.Exists, "Result of %q", test.key)
+		assert.Equal(t, SuffixNone, explanation.SuffixCheck)
+	}
+}
+
+func TestExplainAgreesWithLookup(t *testing.T) {
+	keys := benchmarkKeys(200)
+
+	for _, layout := range []DenseLayout{LayoutSeparate, LayoutInterleaved} {
+		surf, err := New(keys, WithDenseLayout(layout))
+		if err != nil {
+			t.Fatalf("Error creating SuRF store: %v", err)
+		}
+
+		for _, query := range batchQueries(keys) {
+			exists, err := surf.Lookup(query)
+			assert.Nil(t, err)
+
+			explanation, err := surf.Explain(query)
+			assert.Nil(t, err)
+			assert.Equal(t, exists, explanation.Exists, "Explain(%q) with layout %v", query, layout)
+		}
+	}
+}
+
+func TestExplanationString(t *testing.T) {
+	explanation, err := newPaperSURF(t).Explain([]byte("fastest"))
+	assert.Nil(t, err)
+
+	out := explanation.String()
+	assert.Contains(t, out, `Lookup of "fastest": exists = true`)
+	assert.Contains(t, out, `[2] node 3, edge 's' (0x73): label = true, has child = true, rank = 6`)
+	assert.Contains(t, out, `[3] node 6, edge 't' (0x74): label = true, has child = false`)
+	assert.Contains(t, out, "ended at node 6: Leaf")
+	assert.Contains(t, out, "suffix check: none, as suffixes are not stored")
+}
@@ -0,0 +1,251 @@
+// fpr provides a harness to empirically measure the false-positive rate of
+// SuRF stores.
+//
+// It builds stores from a key set under varying options, and looks up
+// negative point and range queries produced by a set of generators, reporting
+// the fraction of them which (falsely) matched.
+package fpr
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/Lavode/surf/store"
+	"golang.org/x/exp/slices"
+)
+
+// ErrNoNegatives indicates that a generator failed to produce enough queries
+// which are negative with respect to the key set.
+var ErrNoNegatives = errors.New("Generator failed to produce negative queries")
+
+// maxAttemptsPerQuery bounds the number of candidates drawn from a generator
+// per negative query which is needed.
+const maxAttemptsPerQuery = 100
+
+// Generator produces candidate queries.
+//
+// Point produces a candidate point query. Candidates which turn out to be
+// stored keys are discarded by the harness, so generators need not ensure
+// that they are negative.
+//
+// Range queries are derived from point queries: A range starts at a candidate
+// point query low, and ends at low with its last byte incremented by a random
+// amount in [1, RangeWidth]. As with point queries, candidates containing a
+// stored key are discarded.
+type Generator struct {
+	// Name identifies the generator in results.
+	Name string
+	// Point produces a candidate point query. keys is the sorted key set
+	// of the store being measured.
+	Point func(rng *rand.Rand, keys [][]byte) []byte
+}
+
+// Config is a set of options under which stores are built.
+type Config struct {
+	// Name identifies the configuration in results.
+	Name string
+	// Options are passed to store.New.
+	Options []store.Option
+}
+
+// Harness measures the false-positive rate of stores built from a fixed key
+// set.
+type Harness struct {
+	// Keys is the key set the stores are built from.
+	Keys [][]byte
+	// Queries is the number of negative point and range queries each
+	// generator produces per configuration.
+	Queries int
+	// RangeWidth bounds the width of range queries, see Generator.
+	RangeWidth int
+	// Seed seeds the generators, such that measurements are reproducible.
+	Seed int64
+}
+
+// Result holds the false-positive rates measured for one combination of
+// configuration and generator.
+type Result struct {
+	// Config is the name of the configuration.
+	Config string
+	// Generator is the name of the generator.
+	Generator string
+
+	// PointQueries is the number of negative point queries looked up.
+	PointQueries int
+	// PointFalsePositives is the number of point queries which matched.
+	PointFalsePositives int
+	// RangeQueries is the number of negative range queries looked up.
+	RangeQueries int
+	// RangeFalsePositives is the number of range queries which matched.
+	RangeFalsePositives int
+}
+
+// PointFPR returns the measured false-positive rate of point queries.
+func (result Result) PointFPR() float64 {
+	if result.PointQueries == 0 {
+		return 0
+	}
+
+	return float64(result.PointFalsePositives) / float64(result.PointQueries)
+}
+
+// RangeFPR returns the measured false-positive rate of range queries.
+func (result Result) RangeFPR() float64 {
+	if result.RangeQueries == 0 {
+		return 0
+	}
+
+	return float64(result.RangeFalsePositives) / float64(result.RangeQueries)
+}
+
+// Measure builds one store per configuration and measures its false-positive
+// rate with each generator.
+//
+// Results are returned in order of configurations, then generators. An error
+// is returned if a store cannot be built, or if a generator fails to produce
+// enough negative queries, in which case it wraps ErrNoNegatives.
+func (harness *Harness) Measure(configs []Config, generators []Generator) ([]Result, error) {
+	keys := make([][]byte, len(harness.Keys))
+	copy(keys, harness.Keys)
+	sort.Slice(keys, func(i, j int) bool {
+		return bytes.Compare(keys[i], keys[j]) < 0
+	})
+
+	results := make([]Result, 0, len(configs)*len(generators))
+	for _, config := range configs {
+		surf, err := store.New(keys, config.Options...)
+		if err != nil {
+			return nil, fmt.Errorf("Error creating SuRF store for config %s: %w", config.Name, err)
+		}
+
+		for _, generator := range generators {
+			// Reseeding per pair ensures that all configurations
+			// face the same queries.
+			rng := rand.New(rand.NewSource(harness.Seed))
+
+			result, err := harness.measure(surf, keys, generator, rng)
+			if err != nil {
+				return nil, fmt.Errorf("Error measuring config %s with generator %s: %w", config.Name, generator.Name, err)
+			}
+			result.Config = config.Name
+
+			results = append(results, result)
+		}
+	}
+
+	return results, nil
+}
+
+// measure measures the false-positive rate of a single store with a single
+// generator.
+func (harness *Harness) measure(surf *store.SURF, keys [][]byte, generator Generator, rng *rand.Rand) (Result, error) {
+	result := Result{Generator: generator.Name}
+	maxAttempts := maxAttemptsPerQuery * harness.Queries
+
+	for attempts := 0; result.PointQueries < harness.Queries; attempts++ {
+		if attempts == maxAttempts {
+			return result, fmt.Errorf("%w: %d of %d point queries", ErrNoNegatives, result.PointQueries, harness.Queries)
+		}
+
+		query := generator.Point(rng, keys)
+		if containsKey(keys, query, query) {
+			continue
+		}
+
+		exists, err := surf.Lookup(query)
+		if err != nil {
+			return result, err
+		}
+
+		result.PointQueries++
+		if exists {
+			result.PointFalsePositives++
+		}
+	}
+
+	for attempts := 0; result.RangeQueries < harness.Queries; attempts++ {
+		if attempts == maxAttempts {
+			return result, fmt.Errorf("%w: %d of %d range queries", ErrNoNegatives, result.RangeQueries, harness.Queries)
+		}
+
+		low := generator.Point(rng, keys)
+		high := harness.rangeEnd(rng, low)
+		if containsKey(keys, low, high) {
+			continue
+		}
+
+		exists, err := surf.RangeLookup(low, high)
+		if err != nil {
+			return result, err
+		}
+
+		result.RangeQueries++
+		if exists {
+			result.RangeFalsePositives++
+		}
+	}
+
+	return result, nil
+}
+
+// rangeEnd returns the upper bound of a range query starting at low.
+//
+// It is low with a random width of up to RangeWidth added to its last byte,
+// carrying into the preceding bytes as in a big-endian number. If low consists
+// of 0xFF bytes only, such that there is no greater key of the same length,
+// it is extended by a byte instead.
+func (harness *Harness) rangeEnd(rng *rand.Rand, low []byte) []byte {
+	width := 1
+	if harness.RangeWidth > 1 {
+		width += rng.Intn(harness.RangeWidth)
+	}
+
+	high := slices.Clone(low)
+	carry := width
+	for i := len(high) - 1; i >= 0 && carry > 0; i-- {
+		sum := int(high[i]) + carry
+		high[i] = byte(sum)
+		carry = sum >> 8
+	}
+
+	if carry > 0 {
+		return append(slices.Clone(low), byte(width-1))
+	}
+
+	return high
+}
+
+// containsKey checks whether any of the sorted keys lies in [low, high].
+func containsKey(keys [][]byte, low, high []byte) bool {
+	idx := sort.Search(len(keys), func(i int) bool {
+		return bytes.Compare(keys[i], low) >= 0
+	})
+
+	return idx < len(keys) && bytes.Compare(keys[idx], high) <= 0
+}
+
+// WriteTable writes results as a human-readable table, e.g. for use in a CLI.
+func WriteTable(w io.Writer, results []Result) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+
+	fmt.Fprintln(tw, "Config\tGenerator\tPoint queries\tPoint FPR\tRange queries\tRange FPR")
+	for _, result := range results {
+		fmt.Fprintf(
+			tw,
+			"%s\t%s\t%d\t%.4f\t%d\t%.4f\n",
+			result.Config,
+			result.Generator,
+			result.PointQueries,
+			result.PointFPR(),
+			result.RangeQueries,
+			result.RangeFPR(),
+		)
+	}
+
+	return tw.Flush()
+}
@@ -0,0 +1,125 @@
+package fpr
+
+import (
+	"bytes"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/Lavode/surf/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func randomKeys(n int) [][]byte {
+	rng := rand.New(rand.NewSource(42))
+	seen := make(map[string]bool, n)
+
+	keys := make([][]byte, 0, n)
+	for len(keys) < n {
+		key := randomBytes(rng, 1, 8)
+		if seen[string(key)] {
+			continue
+		}
+
+		seen[string(key)] = true
+		keys = append(keys, key)
+	}
+
+	return keys
+}
+
+func TestMeasure(t *testing.T) {
+	harness := Harness{
+		Keys:       randomKeys(1000),
+		Queries:    500,
+		RangeWidth: 16,
+		Seed:       42,
+	}
+
+	configs := []Config{
+		{Name: "default"},
+		{Name: "no-suffixes", Options: []store.Option{store.WithSuffixMode(store.SuffixNone)}},
+	}
+	generators := []Generator{Random(1, 8), NearMiss(), SharedPrefix(4)}
+
+	results, err := harness.Measure(configs, generators)
+	assert.Nil(t, err)
+	assert.Len(t, results, 6)
+
+	for i, result := range results {
+		assert.Equal(t, configs[i/3].Name, result.Config)
+		assert.Equal(t, generators[i%3].Name, result.Generator)
+
+		assert.Equal(t, 500, result.PointQueries)
+		assert.Equal(t, 500, result.RangeQueries)
+		assert.GreaterOrEqual(t, result.PointFPR(), 0.0)
+		assert.LessOrEqual(t, result.PointFPR(), 1.0)
+		assert.GreaterOrEqual(t, result.RangeFPR(), 0.0)
+		assert.LessOrEqual(t, result.RangeFPR(), 1.0)
+	}
+
+	// Near misses share the longest prefix with stored keys, so should be
+	// at least as likely to be false positives as random queries.
+	assert.GreaterOrEqual(t, results[1].PointFPR(), results[0].PointFPR())
+
+	var out bytes.Buffer
+	assert.Nil(t, WriteTable(&out, results))
+	assert.Equal(t, 7, strings.Count(out.String(), "\n"))
+}
+
+func TestMeasureNoNegatives(t *testing.T) {
+	// All single-byte keys are stored, so there are no negative
+	// single-byte queries.
+	keys := make([][]byte, 256)
+	for i := range keys {
+		keys[i] = []byte{byte(i)}
+	}
+
+	harness := Harness{Keys: keys, Queries: 10, Seed: 42}
+	_, err := harness.Measure([]Config{{Name: "default"}}, []Generator{Random(1, 1)})
+	assert.ErrorIs(t, err, ErrNoNegatives)
+}
+
+func TestRangeEnd(t *testing.T) {
+	rng := rand.New(rand.NewSource(42))
+	harness := Harness{RangeWidth: 1}
+
+	tests := []struct {
+		low  []byte
+		high []byte
+	}{
+		{[]byte{}, []byte{0x00}},
+		{[]byte("a"), []byte("b")},
+		{[]byte("a\xFE"), []byte("a\xFF")},
+		// The last byte carries into the preceding ones
+		{[]byte("a\xFF"), []byte("b\x00")},
+		{[]byte("a\xFF\xFF"), []byte("b\x00\x00")},
+		// There is no greater key of the same length
+		{[]byte("\xFF\xFF"), []byte("\xFF\xFF\x00")},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.high, harness.rangeEnd(rng, test.low), "Range end of %q", test.low)
+	}
+
+	// Ranges are never empty, nor reduced to a point
+	harness.RangeWidth = 16
+	for i := 0; i < 1000; i++ {
+		low := randomBytes(rng, 0, 3)
+		if i%2 == 0 {
+			low = append(low, 0xFF)
+		}
+
+		high := harness.rangeEnd(rng, low)
+		assert.Equal(t, 1, bytes.Compare(high, low), "Range end %q of %q", high, low)
+	}
+}
+
+func TestContainsKey(t *testing.T) {
+	keys := [][]byte{[]byte("a"), []byte("ab"), []byte("b")}
+
+	assert.True(t, containsKey(keys, []byte("ab"), []byte("ab")))
+	assert.True(t, containsKey(keys, []byte("aa"), []byte("az")))
+	assert.False(t, containsKey(keys, []byte("ac"), []byte("az")))
+	assert.False(t, containsKey(keys, []byte("c"), []byte("d")))
+}
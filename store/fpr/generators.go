@@ -0,0 +1,76 @@
+package fpr
+
+import "math/rand"
+
+// Random returns a generator producing uniformly random queries with lengths
+// in [minLength, maxLength].
+func Random(minLength, maxLength int) Generator {
+	return Generator{
+		Name: "random",
+		Point: func(rng *rand.Rand, keys [][]byte) []byte {
+			return randomBytes(rng, minLength, maxLength)
+		},
+	}
+}
+
+// NearMiss returns a generator producing queries which differ from a stored
+// key only in their last byte, or by one additional byte.
+//
+// These are the hardest case for the trie, as they share the longest possible
+// prefix with a stored key.
+func NearMiss() Generator {
+	return Generator{
+		Name: "near-miss",
+		Point: func(rng *rand.Rand, keys [][]byte) []byte {
+			if len(keys) == 0 {
+				return randomBytes(rng, 1, 1)
+			}
+
+			key := keys[rng.Intn(len(keys))]
+			query := make([]byte, len(key), len(key)+1)
+			copy(query, key)
+
+			if len(query) == 0 || rng.Intn(2) == 0 {
+				return append(query, byte(rng.Intn(256)))
+			}
+
+			query[len(query)-1] = byte(rng.Intn(256))
+			return query
+		},
+	}
+}
+
+// SharedPrefix returns a generator producing queries which share a prefix of
+// random length with a stored key, followed by up to maxSuffixLength random
+// bytes.
+func SharedPrefix(maxSuffixLength int) Generator {
+	return Generator{
+		Name: "shared-prefix",
+		Point: func(rng *rand.Rand, keys [][]byte) []byte {
+			if len(keys) == 0 {
+				return randomBytes(rng, 1, maxSuffixLength)
+			}
+
+			key := keys[rng.Intn(len(keys))]
+			prefix := key[:rng.Intn(len(key)+1)]
+
+			query := make([]byte, len(prefix))
+			copy(query, prefix)
+
+			return append(query, randomBytes(rng, 1, maxSuffixLength)...)
+		},
+	}
+}
+
+// randomBytes returns a random byte slice with a length in [minLength,
+// maxLength].
+func randomBytes(rng *rand.Rand, minLength, maxLength int) []byte {
+	if maxLength < minLength {
+		maxLength = minLength
+	}
+
+	out := make([]byte, minLength+rng.Intn(maxLength-minLength+1))
+	rng.Read(out)
+
+	return out
+}
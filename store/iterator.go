@@ -16,6 +16,7 @@ import (
 	"github.com/Lavode/surf/bitmap"
 	"github.com/Lavode/surf/louds"
 	"github.com/Lavode/surf/stack"
+	"golang.org/x/exp/slices"
 )
 
 // Iterator implements an iterator through a LOUDS-DENSE encoded FST.
@@ -23,16 +24,22 @@ import (
 // It allows to navigate up, down, and along the tree.
 type Iterator struct {
 	// Labels is the D-Labels bitmap of the LOUDS-DENSE encoding.
-	Labels *bitmap.Bitmap
+	Labels bitmap.RankSelect
 	// HasChild is the D-HasChild bitmap of the LOUDS-DENSE encoding.
-	HasChild *bitmap.Bitmap
+	HasChild bitmap.RankSelect
 	// IsPrefixKey is the D-IsPrefixKey bitmap of the LOUDS-DENSE encoding.
-	IsPrefixKey *bitmap.Bitmap
+	IsPrefixKey bitmap.RankSelect
 
 	// NodeIndex is the level-order index of the node the iterator
 	// currently points to.
 	// The node with index 0 is the root node.
 	NodeIndex int
+	// nodeRank is the number of set D-HasChild bits preceding the node
+	// rankedNode, or -1 if it is not known. For the current node, it
+	// allows to compute the index of a child by only counting the bits
+	// within the node, rather than from the start of the bitmap.
+	nodeRank   int
+	rankedNode int
 	// nodes is the stack of node indices we have visited along our path
 	nodes stack.Stack[int]
 
@@ -48,6 +55,12 @@ type Iterator struct {
 	// keyPrefix is the sequence of bytes defining the key leading up to
 	// the current node.
 	keyPrefix stack.Stack[byte]
+
+	// visitedRoot indicates whether the iterator has moved past the root
+	// node's prefix key, that is the empty key. Unlike other nodes' prefix
+	// keys, it is not yielded upon diving into the node, but by the first
+	// call to Next.
+	visitedRoot bool
 }
 
 // ErrNoSuchEdge indicates that the requested edge does not exist.
@@ -57,6 +70,10 @@ var ErrNoSuchEdge = errors.New("Cannot move to non-existant edge")
 // cannot be travelled to.
 var ErrIsLeaf = errors.New("Cannot move to leaf node")
 
+// ErrIsRoot indicates that the iterator is at the root node, which has no
+// parent to move up to.
+var ErrIsRoot = errors.New("Cannot move up from root node")
+
 // ErrEndOfTrie indicates that trie traversal reached the end of the trie.
 var ErrEndOfTrie = errors.New("Reached end of trie")
 
@@ -80,8 +97,10 @@ func (it *Iterator) GoToChild(edge byte) error {
 		return fmt.Errorf("Error accessing bit %d of Labels: %v", offset, err)
 	}
 
+	// Misses are part of ordinary lookups, so we return the sentinel
+	// errors as they are, to not allocate.
 	if hasLabel != 1 {
-		return fmt.Errorf("%w: %d", ErrNoSuchEdge, edge)
+		return ErrNoSuchEdge
 	}
 
 	hasChild, err := it.HasChild.Get(offset)
@@ -90,78 +109,224 @@ func (it *Iterator) GoToChild(edge byte) error {
 	}
 
 	if hasChild != 1 {
-		return fmt.Errorf("%w: %d", ErrIsLeaf, edge)
+		return ErrIsLeaf
 	}
 
 	// Index of node this edge points to is given by:
 	// rank_1(D-HasChild, offset)
-	nextNode, err := it.HasChild.Rank(1, offset)
+	nodeRank, err := it.rank()
+	if err != nil {
+		return err
+	}
+
+	nodeStart := 256 * it.NodeIndex
+	inNode, err := bitmap.CountOnes(it.HasChild, nodeStart, offset+1)
 	if err != nil {
-		return fmt.Errorf("Error calculating rank_1(%d) over HasChild: %v", offset, err)
+		return fmt.Errorf("Error counting ones of HasChild in [%d, %d): %v", nodeStart, offset+1, err)
 	}
+	nextNode := nodeRank + inNode
 
 	// Update path we took to get to new node
+	it.visitedRoot = true
 	it.keyPrefix.Push(byte(it.nextEdge))
 	it.nodes.Push(it.NodeIndex)
 	it.edges.Push(int(edge))
 
 	it.NodeIndex = nextNode
+	// In a wide trie, the child may be far from its parent, so its rank is
+	// only computed once it is needed.
+	it.rankedNode = -1
 	it.nextEdge = 0 // We'll start at the first edge of the new node
 
 	return nil
 }
 
+// GoToParent moves up to the parent of the current node.
+//
+// The next edge to be visited by Next will be the one following the edge we
+// came from.
+//
+// If the iterator is at the root node, ErrIsRoot is returned.
+// If an error occurs in the underlying data structure, a generic error is
+// returned.
+func (it *Iterator) GoToParent() error {
+	if it.nodes.Len() == 0 {
+		return ErrIsRoot
+	}
+
+	parent := it.nodes.At(it.nodes.Len() - 1)
+	edge := it.edges.At(it.edges.Len() - 1)
+
+	// The child's index is the rank of the edge leading to it, so the
+	// parent's rank follows by discounting the bits within the parent.
+	// It is computed before moving, such that an error leaves the
+	// iterator where it was.
+	nodeStart := 256 * parent
+	inNode, err := bitmap.CountOnes(it.HasChild, nodeStart, nodeStart+edge+1)
+	if err != nil {
+		return fmt.Errorf("Error counting ones of HasChild in [%d, %d): %v", nodeStart, nodeStart+edge+1, err)
+	}
+
+	it.nodeRank = it.NodeIndex - inNode
+	it.rankedNode = parent
+
+	it.NodeIndex = it.nodes.Pop()
+	it.edges.Pop()
+	it.nextEdge = edge + 1 // Don't want to dive down the same edge again
+	it.keyPrefix.Pop()
+
+	return nil
+}
+
+// rank returns the number of set D-HasChild bits preceding the current node.
+//
+// It is computed once per node, rather than once per child, and carried over
+// when moving back up to a parent. Computing it takes a single rank query,
+// which is cheap given a rank index such as the one the LOUDS-DENSE builder
+// builds for D-HasChild.
+func (it *Iterator) rank() (int, error) {
+	if it.rankedNode == it.NodeIndex {
+		return it.nodeRank, nil
+	}
+
+	rank := 0
+	if it.NodeIndex > 0 {
+		var err error
+		rank, err = it.HasChild.Rank(1, 256*it.NodeIndex-1)
+		if err != nil {
+			return 0, fmt.Errorf("Error calculating rank_1(%d) over HasChild: %v", 256*it.NodeIndex-1, err)
+		}
+	}
+
+	it.nodeRank = rank
+	it.rankedNode = it.NodeIndex
+
+	return rank, nil
+}
+
+// Depth returns the depth of the current node, that is the length of the key
+// leading up to it. The root node has depth 0.
+func (it *Iterator) Depth() int {
+	return it.keyPrefix.Len()
+}
+
+// ascendTo moves up to the deepest node on the current path whose key is a
+// prefix of key.
+func (it *Iterator) ascendTo(key []byte) error {
+	shared := 0
+	for shared < it.Depth() && shared < len(key) && it.keyPrefix.At(shared) == key[shared] {
+		shared++
+	}
+
+	for it.Depth() > shared {
+		err := it.GoToParent()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// reset moves the iterator back to the root node, as if it was newly created.
+//
+// The memory backing its stacks is retained for reuse.
+func (it *Iterator) reset() {
+	it.NodeIndex = 0
+	it.nodeRank = 0
+	it.rankedNode = 0
+	it.nextEdge = 0
+	it.visitedRoot = false
+	it.nodes.Reset()
+	it.edges.Reset()
+	it.keyPrefix.Reset()
+}
+
 // Next moves to and returns the next key in lexicographic order.
 //
 // Once the end of the trie is reached, ErrEndOfTrie is returned.
 func (it *Iterator) Next() (louds.Key, error) {
+	key, err := it.next()
+	if err != nil {
+		return nil, err
+	}
+
+	return slices.Clone(key), nil
+}
+
+// next works like Next, but does not copy the returned key. It is thus only
+// valid until the iterator is next moved.
+func (it *Iterator) next() (louds.Key, error) {
+	if !it.visitedRoot {
+		it.visitedRoot = true
+
+		isPrefixKey, err := it.IsPrefixKey.Get(0)
+		if err != nil {
+			return nil, err
+		}
+
+		if isPrefixKey == 1 {
+			// The empty key is stored, which precedes all
+			// others.
+			return louds.Key{}, nil
+		}
+	}
+
 	for {
 		// We first attempt to go depth-first down the first available edge.
-		// TODO This is not a very elegant solution for LOUDS-DENSE, but will
-		// do for now.
-		for ; it.nextEdge < 256; it.nextEdge++ {
-			err := it.GoToChild(byte(it.nextEdge))
-			if errors.Is(err, ErrNoSuchEdge) {
-				continue
-			} else if errors.Is(err, ErrIsLeaf) {
+		for it.nextEdge < 256 {
+			// Rather than probing each possible edge value, we
+			// scan for the next one which exists.
+			nodeStart := 256 * it.NodeIndex
+			label, found, err := bitmap.NextOne(it.Labels, nodeStart+it.nextEdge)
+			if err != nil {
+				return nil, fmt.Errorf("Error finding next 1-bit of Labels from %d: %v", nodeStart+it.nextEdge, err)
+			}
+			if !found || label >= nodeStart+256 {
+				break
+			}
+			edge := label - nodeStart
+
+			err = it.GoToChild(byte(edge))
+			if errors.Is(err, ErrIsLeaf) {
 				// While we can't traverse to a leaf, it's certainly a
 				// value we can yield.
-				key := it.keyPrefix.Data()
-				key = append(key, byte(it.nextEdge))
-				it.nextEdge++
+				// Pushing the edge onto the prefix, rather
+				// than appending to a view of it, lets the
+				// prefix's buffer grow for future reuse.
+				it.keyPrefix.Push(byte(edge))
+				key := it.keyPrefix.View()
+				it.keyPrefix.Pop()
+
+				it.nextEdge = edge + 1
 				return key, nil
 			} else if err != nil {
 				// Something went awry
 				return nil, err
-			} else {
-				// We actually managed to dive down one level.
-
-				isPrefixKey, err := it.IsPrefixKey.Get(it.NodeIndex)
-				if err != nil {
-					return nil, err
-				}
-
-				if isPrefixKey == 1 {
-					// The node we dove to is a prefix key, so is
-					// the next key in the sequence.
-					key := it.keyPrefix.Data()
+			}
 
-					return key, nil
-				}
+			// We actually managed to dive down one level.
+			isPrefixKey, err := it.IsPrefixKey.Get(it.NodeIndex)
+			if err != nil {
+				return nil, err
+			}
 
+			if isPrefixKey == 1 {
+				// The node we dove to is a prefix key, so is
+				// the next key in the sequence.
+				return it.keyPrefix.View(), nil
 			}
 		}
 
 		// We exhausted all possible edges in the current node, so must go up
 		// one level.
-		if it.NodeIndex == 0 {
-			// But if we're at the root node, there's no way to go
-			// up, we traversed the whole trie.
+		// But if we're at the root node, there's no way to go up, we
+		// traversed the whole trie.
+		err := it.GoToParent()
+		if errors.Is(err, ErrIsRoot) {
 			return nil, ErrEndOfTrie
-		} else {
-			it.NodeIndex = it.nodes.Pop()
-			it.nextEdge = it.edges.Pop() + 1 // Don't want to dive down the same edge again
-			it.keyPrefix.Pop()
+		} else if err != nil {
+			return nil, err
 		}
 	}
 }
@@ -74,6 +74,41 @@ func TestGoToChild(t *testing.T) {
 	assert.Equal(t, 7, it.NodeIndex)
 }
 
+func TestGoToParentError(t *testing.T) {
+	labels, hasChild, isPrefixKey := buildFST(t)
+	it := Iterator{
+		Labels:      labels,
+		HasChild:    hasChild,
+		IsPrefixKey: isPrefixKey,
+	}
+
+	// root -> t -> r
+	assert.Nil(t, it.GoToChild('t'))
+	assert.Nil(t, it.GoToChild('r'))
+	assert.Equal(t, 5, it.NodeIndex)
+
+	// With D-HasChild truncated to the root node, the rank of node t
+	// cannot be computed, which must leave the iterator unchanged.
+	truncated, err := hasChild.Slice(0, 256)
+	assert.Nil(t, err)
+	it.HasChild = truncated
+
+	assert.NotNil(t, it.GoToParent())
+	assert.Equal(t, 5, it.NodeIndex)
+	assert.Equal(t, 2, it.Depth())
+	assert.Equal(t, []byte("tr"), it.keyPrefix.View())
+
+	it.HasChild = hasChild
+	assert.Nil(t, it.GoToParent())
+	assert.Equal(t, 2, it.NodeIndex)
+	assert.Equal(t, 1, it.Depth())
+
+	// r -> i
+	assert.Nil(t, it.GoToChild('r'))
+	assert.Nil(t, it.GoToChild('i'))
+	assert.Equal(t, 7, it.NodeIndex)
+}
+
 func TestNext(t *testing.T) {
 	labels, hasChild, isPrefixKey := buildFST(t)
 	it := Iterator{
@@ -196,3 +231,27 @@ func TestNextAndGotoChild(t *testing.T) {
 	err = it.GoToChild('p')
 	assert.ErrorIs(t, err, ErrIsLeaf)
 }
+
+func BenchmarkNext(b *testing.B) {
+	keys := benchmarkKeys(10_000)
+	surf, err := New(keys)
+	if err != nil {
+		b.Fatalf("Error creating SuRF store: %v", err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		it := Iterator{
+			Labels:      surf.DenseLabels,
+			HasChild:    surf.DenseHasChild,
+			IsPrefixKey: surf.DenseIsPrefixKey,
+		}
+
+		for {
+			_, err := it.Next()
+			if err != nil {
+				break
+			}
+		}
+	}
+}
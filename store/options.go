@@ -1,10 +1,142 @@
 package store
 
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrInvalidOption indicates that an option, or a combination thereof, is
+// invalid.
+var ErrInvalidOption = errors.New("Invalid option")
+
+// maxSuffixBits is the maximum number of hash respectively real bits which can
+// be stored per key.
+const maxSuffixBits = 64
+
+// SuffixMode defines which kinds of suffixes are stored alongside keys.
+type SuffixMode int
+
+const (
+	// SuffixNone stores no suffixes. Both HashBits and RealBits are 0.
+	SuffixNone SuffixMode = iota
+	// SuffixHash stores hash suffixes only. RealBits is 0.
+	SuffixHash
+	// SuffixReal stores real suffixes only. HashBits is 0.
+	SuffixReal
+	// SuffixMixed stores both hash and real suffixes.
+	SuffixMixed
+)
+
+func (mode SuffixMode) String() string {
+	switch mode {
+	case SuffixNone:
+		return "None"
+	case SuffixHash:
+		return "Hash"
+	case SuffixReal:
+		return "Real"
+	case SuffixMixed:
+		return "Mixed"
+	default:
+		return fmt.Sprintf("SuffixMode(%d)", int(mode))
+	}
+}
+
+// Option configures a SuRF store.
+//
+// It is implemented by SURFOptions, as well as by the values returned by the
+// With* functions. Options are applied in order, with later ones taking
+// precedence. Only the resulting options are validated, such that struct and
+// functional options are subject to the same checks.
+type Option interface {
+	apply(options *SURFOptions)
+}
+
+// optionFunc adapts a function to the Option interface.
+type optionFunc func(options *SURFOptions)
+
+func (f optionFunc) apply(options *SURFOptions) {
+	f(options)
+}
+
+// WithR sets the ratio between the sizes of the sparse and dense LOUDS
+// encodings. See SURFOptions.R for details.
+func WithR(r uint) Option {
+	return optionFunc(func(options *SURFOptions) {
+		options.R = &r
+	})
+}
+
+// WithHashBits sets the number of hash bits stored per key. See
+// SURFOptions.HashBits for details.
+func WithHashBits(hashBits uint) Option {
+	return optionFunc(func(options *SURFOptions) {
+		options.HashBits = &hashBits
+	})
+}
+
+// WithRealBits sets the number of real bits stored per key. See
+// SURFOptions.RealBits for details.
+func WithRealBits(realBits uint) Option {
+	return optionFunc(func(options *SURFOptions) {
+		options.RealBits = &realBits
+	})
+}
+
+// WithMemoryLimit sets the memory limit, in bits. See
+// SURFOptions.MemoryLimit for details.
+func WithMemoryLimit(bits int) Option {
+	return optionFunc(func(options *SURFOptions) {
+		options.MemoryLimit = &bits
+	})
+}
+
+// WithSuffixMode sets which kinds of suffixes are stored. See
+// SURFOptions.SuffixMode for details.
+func WithSuffixMode(mode SuffixMode) Option {
+	return optionFunc(func(options *SURFOptions) {
+		options.SuffixMode = &mode
+	})
+}
+
+// WithLabelsEncoding sets the encoding of the D-Labels bitmap. See
+// SURFOptions.LabelsEncoding for details.
+func WithLabelsEncoding(enc BitmapEncoding) Option {
+	return optionFunc(func(options *SURFOptions) {
+		options.LabelsEncoding = &enc
+	})
+}
+
+// WithHasChildEncoding sets the encoding of the D-HasChild bitmap. See
+// SURFOptions.HasChildEncoding for details.
+func WithHasChildEncoding(enc BitmapEncoding) Option {
+	return optionFunc(func(options *SURFOptions) {
+		options.HasChildEncoding = &enc
+	})
+}
+
+// WithIsPrefixKeyEncoding sets the encoding of the D-IsPrefixKey bitmap. See
+// SURFOptions.IsPrefixKeyEncoding for details.
+func WithIsPrefixKeyEncoding(enc BitmapEncoding) Option {
+	return optionFunc(func(options *SURFOptions) {
+		options.IsPrefixKeyEncoding = &enc
+	})
+}
+
+// WithDenseLayout sets the layout of the D-Labels and D-HasChild bitmaps. See
+// SURFOptions.DenseLayout for details.
+func WithDenseLayout(layout DenseLayout) Option {
+	return optionFunc(func(options *SURFOptions) {
+		options.DenseLayout = &layout
+	})
+}
+
 // SURFOptions serves as an options struct to hold parmaeters for a specific
 // SURF instantiation.
-// TODO instantiating this is awkward due to the use of pointers (cannot
-// instantiante inline). Might be more elegant ways around, check stdlib for
-// examples.
+//
+// Its fields are pointers, such that unset ones can be told apart and set to
+// their defaults. The With* functions allow to set them without having to
+// take the address of a local variable.
 type SURFOptions struct {
 	// R is the ratio between the sizes of the sparse and dense LOUDS
 	// encodings.
@@ -48,10 +180,108 @@ type SURFOptions struct {
 	// The default is 4.
 	RealBits *uint
 
-	// MemoryLimit sets the memory limit, in bytes, of the LOUDS encoding.
+	// MemoryLimit sets the memory limit, in bits, of the SuRF store.
+	//
+	// It applies to the peak memory needed while building the store. That
+	// is the sum of all encodings making up the store, as reported by
+	// EstimateSize, plus the plain bitmaps from which bitmaps of other
+	// encodings or layouts are derived. If it is exceeded, New returns a
+	// *MemoryLimitError.
 	//
-	// The default is 256 MB
+	// The default is 2'048'000'000 bits, that is 256 MB.
 	MemoryLimit *int
+
+	// SuffixMode defines which kinds of suffixes are stored.
+	//
+	// If it is set, HashBits and RealBits must be consistent with it. That
+	// is, they must be non-zero exactly for the kinds of suffixes which are
+	// stored. If they are unset, they default to 4 for kinds of suffixes
+	// which are stored, and to 0 for ones which are not.
+	//
+	// The default is derived from HashBits and RealBits.
+	SuffixMode *SuffixMode
+
+	// LabelsEncoding, HasChildEncoding and IsPrefixKeyEncoding define how
+	// the D-Labels, D-HasChild and D-IsPrefixKey bitmaps are represented in
+	// memory, allowing to pick a space/time trade-off per bitmap.
+	//
+	// The sizes reported by EstimateSize are those of the configured
+	// encodings. See MemoryLimit for the memory needed while building the
+	// store, which happens in the plain encoding.
+	//
+	// The default is EncodingPlain for all of them.
+	LabelsEncoding      *BitmapEncoding
+	HasChildEncoding    *BitmapEncoding
+	IsPrefixKeyEncoding *BitmapEncoding
+
+	// DenseLayout defines how the D-Labels and D-HasChild bitmaps are laid
+	// out in memory. LayoutInterleaved co-locates the data needed to
+	// follow an edge, at the cost of padding each node to 1024 bits. This
+	// is accounted for by EstimateSize and MemoryLimit.
+	//
+	// LayoutInterleaved requires LabelsEncoding and HasChildEncoding to be
+	// EncodingPlain.
+	//
+	// The default is LayoutSeparate.
+	DenseLayout *DenseLayout
+}
+
+// apply copies the fields of options which are set onto target.
+func (options SURFOptions) apply(target *SURFOptions) {
+	if options.R != nil {
+		target.R = options.R
+	}
+
+	if options.HashBits != nil {
+		target.HashBits = options.HashBits
+	}
+
+	if options.RealBits != nil {
+		target.RealBits = options.RealBits
+	}
+
+	if options.MemoryLimit != nil {
+		target.MemoryLimit = options.MemoryLimit
+	}
+
+	if options.SuffixMode != nil {
+		target.SuffixMode = options.SuffixMode
+	}
+
+	if options.LabelsEncoding != nil {
+		target.LabelsEncoding = options.LabelsEncoding
+	}
+
+	if options.HasChildEncoding != nil {
+		target.HasChildEncoding = options.HasChildEncoding
+	}
+
+	if options.IsPrefixKeyEncoding != nil {
+		target.IsPrefixKeyEncoding = options.IsPrefixKeyEncoding
+	}
+
+	if options.DenseLayout != nil {
+		target.DenseLayout = options.DenseLayout
+	}
+}
+
+// newOptions applies the given options in order, sets defaults for the
+// remaining ones and validates the result.
+func newOptions(opts ...Option) (SURFOptions, error) {
+	options := SURFOptions{}
+
+	for _, opt := range opts {
+		opt.apply(&options)
+	}
+
+	options.setDefaults()
+
+	err := options.validate()
+	if err != nil {
+		return SURFOptions{}, err
+	}
+
+	return options, nil
 }
 
 // setDefaults sets default values.
@@ -63,16 +293,132 @@ func (options *SURFOptions) setDefaults() {
 
 	if options.HashBits == nil {
 		var x uint = 4
+		if options.SuffixMode != nil && !options.SuffixMode.hasHash() {
+			x = 0
+		}
 		options.HashBits = &x
 	}
 
 	if options.RealBits == nil {
 		var x uint = 4
+		if options.SuffixMode != nil && !options.SuffixMode.hasReal() {
+			x = 0
+		}
 		options.RealBits = &x
 	}
 
+	if options.SuffixMode == nil {
+		x := suffixModeOf(*options.HashBits, *options.RealBits)
+		options.SuffixMode = &x
+	}
+
 	if options.MemoryLimit == nil {
-		var x int = 256_000_000
+		var x int = 2_048_000_000
 		options.MemoryLimit = &x
 	}
+
+	for _, enc := range []**BitmapEncoding{&options.LabelsEncoding, &options.HasChildEncoding, &options.IsPrefixKeyEncoding} {
+		if *enc == nil {
+			x := EncodingPlain
+			*enc = &x
+		}
+	}
+
+	if options.DenseLayout == nil {
+		x := LayoutSeparate
+		options.DenseLayout = &x
+	}
+}
+
+// validate checks the options, which must have had their defaults set, for
+// invalid values and combinations thereof.
+//
+// An error wrapping ErrInvalidOption is returned for the first violation.
+func (options *SURFOptions) validate() error {
+	if *options.R == 0 {
+		return fmt.Errorf("%w: R must be positive", ErrInvalidOption)
+	}
+
+	if *options.HashBits > maxSuffixBits {
+		return fmt.Errorf("%w: HashBits must be at most %d. Was %d", ErrInvalidOption, maxSuffixBits, *options.HashBits)
+	}
+
+	if *options.RealBits > maxSuffixBits {
+		return fmt.Errorf("%w: RealBits must be at most %d. Was %d", ErrInvalidOption, maxSuffixBits, *options.RealBits)
+	}
+
+	if *options.MemoryLimit < 0 {
+		return fmt.Errorf("%w: MemoryLimit must not be negative. Was %d", ErrInvalidOption, *options.MemoryLimit)
+	}
+
+	mode := *options.SuffixMode
+	if mode < SuffixNone || mode > SuffixMixed {
+		return fmt.Errorf("%w: Unknown suffix mode %v", ErrInvalidOption, mode)
+	}
+
+	encodings := []struct {
+		name string
+		enc  BitmapEncoding
+	}{
+		{"LabelsEncoding", *options.LabelsEncoding},
+		{"HasChildEncoding", *options.HasChildEncoding},
+		{"IsPrefixKeyEncoding", *options.IsPrefixKeyEncoding},
+	}
+	for _, e := range encodings {
+		if !e.enc.valid() {
+			return fmt.Errorf("%w: Unknown %s %v", ErrInvalidOption, e.name, e.enc)
+		}
+	}
+
+	layout := *options.DenseLayout
+	if !layout.valid() {
+		return fmt.Errorf("%w: Unknown dense layout %v", ErrInvalidOption, layout)
+	}
+
+	if layout == LayoutInterleaved && (*options.LabelsEncoding != EncodingPlain || *options.HasChildEncoding != EncodingPlain) {
+		return fmt.Errorf(
+			"%w: Dense layout %v requires plain D-Labels and D-HasChild, but encodings were %v and %v",
+			ErrInvalidOption,
+			layout,
+			*options.LabelsEncoding,
+			*options.HasChildEncoding,
+		)
+	}
+
+	if suffixModeOf(*options.HashBits, *options.RealBits) != mode {
+		return fmt.Errorf(
+			"%w: Suffix mode %v inconsistent with HashBits = %d and RealBits = %d",
+			ErrInvalidOption,
+			mode,
+			*options.HashBits,
+			*options.RealBits,
+		)
+	}
+
+	return nil
+}
+
+// hasHash returns whether hash suffixes are stored in this mode.
+func (mode SuffixMode) hasHash() bool {
+	return mode == SuffixHash || mode == SuffixMixed
+}
+
+// hasReal returns whether real suffixes are stored in this mode.
+func (mode SuffixMode) hasReal() bool {
+	return mode == SuffixReal || mode == SuffixMixed
+}
+
+// suffixModeOf returns the suffix mode corresponding to the given number of
+// hash and real bits.
+func suffixModeOf(hashBits, realBits uint) SuffixMode {
+	switch {
+	case hashBits > 0 && realBits > 0:
+		return SuffixMixed
+	case hashBits > 0:
+		return SuffixHash
+	case realBits > 0:
+		return SuffixReal
+	default:
+		return SuffixNone
+	}
 }
@@ -0,0 +1,97 @@
+package store
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFunctionalOptions(t *testing.T) {
+	keys := [][]byte{[]byte("a"), []byte("b")}
+
+	surf, err := New(keys, WithR(20), WithHashBits(30), WithRealBits(40), WithMemoryLimit(1_000_000))
+	assert.Nil(t, err)
+	assert.Equal(t, uint(20), surf.R)
+	assert.Equal(t, uint(30), surf.HashBits)
+	assert.Equal(t, uint(40), surf.RealBits)
+
+	// Struct and functional options may be mixed, with later ones taking
+	// precedence.
+	var r uint = 10
+	surf, err = New(keys, SURFOptions{R: &r}, WithHashBits(8))
+	assert.Nil(t, err)
+	assert.Equal(t, uint(10), surf.R)
+	assert.Equal(t, uint(8), surf.HashBits)
+
+	surf, err = New(keys, WithR(10), SURFOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, uint(10), surf.R)
+
+	// Only the resulting options are validated, so invalid ones may be
+	// overridden.
+	surf, err = New(keys, WithR(0), WithR(10))
+	assert.Nil(t, err)
+	assert.Equal(t, uint(10), surf.R)
+
+	_, err = New(keys, WithMemoryLimit(100))
+	assert.ErrorIs(t, err, ErrMemoryLimitExceeded)
+}
+
+func TestSuffixModeDefaults(t *testing.T) {
+	tests := []struct {
+		opts     []Option
+		mode     SuffixMode
+		hashBits uint
+		realBits uint
+	}{
+		{[]Option{}, SuffixMixed, 4, 4},
+		{[]Option{WithSuffixMode(SuffixNone)}, SuffixNone, 0, 0},
+		{[]Option{WithSuffixMode(SuffixHash)}, SuffixHash, 4, 0},
+		{[]Option{WithSuffixMode(SuffixReal)}, SuffixReal, 0, 4},
+		{[]Option{WithSuffixMode(SuffixHash), WithHashBits(12)}, SuffixHash, 12, 0},
+		{[]Option{WithHashBits(0), WithRealBits(0)}, SuffixNone, 0, 0},
+		{[]Option{WithHashBits(0)}, SuffixReal, 0, 4},
+	}
+
+	for _, test := range tests {
+		options, err := newOptions(test.opts...)
+		assert.Nil(t, err)
+		assert.Equal(t, test.mode, *options.SuffixMode)
+		assert.Equal(t, test.hashBits, *options.HashBits)
+		assert.Equal(t, test.realBits, *options.RealBits)
+	}
+}
+
+func TestInvalidOptions(t *testing.T) {
+	var hashBits uint = 65
+	var zero uint = 0
+	limit := -1
+	unknownEncoding := BitmapEncoding(5)
+
+	tests := [][]Option{
+		{WithR(0)},
+		{WithHashBits(65)},
+		{WithRealBits(100)},
+		{WithMemoryLimit(-1)},
+		{WithSuffixMode(SuffixMode(17))},
+		{WithSuffixMode(SuffixNone), WithHashBits(4)},
+		{WithSuffixMode(SuffixHash), WithHashBits(0)},
+		{WithSuffixMode(SuffixReal), WithHashBits(4), WithRealBits(4)},
+		{WithSuffixMode(SuffixMixed), WithRealBits(0)},
+		{WithLabelsEncoding(BitmapEncoding(-1))},
+		{WithHasChildEncoding(BitmapEncoding(2))},
+		{WithIsPrefixKeyEncoding(BitmapEncoding(3))},
+		{WithDenseLayout(DenseLayout(2))},
+		{WithDenseLayout(LayoutInterleaved), WithHasChildEncoding(EncodingEliasFano)},
+		// Struct options are validated just as well
+		{SURFOptions{HashBits: &hashBits}},
+		{SURFOptions{R: &zero}},
+		{SURFOptions{MemoryLimit: &limit}},
+		{SURFOptions{HasChildEncoding: &unknownEncoding}},
+	}
+
+	for _, opts := range tests {
+		_, err := New([][]byte{[]byte("a")}, opts...)
+		assert.ErrorIs(t, err, ErrInvalidOption)
+	}
+}
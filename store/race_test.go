@@ -0,0 +1,7 @@
+//go:build race
+
+package store
+
+// raceEnabled is whether the race detector is enabled. It makes sync.Pool
+// drop items at random, so pooled lookups may allocate.
+const raceEnabled = true
@@ -0,0 +1,98 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/Lavode/surf/louds"
+)
+
+// AnyInRanges checks whether any of ranges contains a key.
+//
+// ranges must be sorted by their lower boundary, else an error wrapping
+// ErrUnsortedInput is returned. They may overlap.
+//
+// As with RangeLookup, there is the possibility of false positives.
+func (surf *SURF) AnyInRanges(ranges []Range) (bool, error) {
+	found := false
+	err := surf.sweepRanges(ranges, func(i int, exists bool) bool {
+		found = exists
+		return !exists
+	})
+
+	return found, err
+}
+
+// WhichRanges checks for each of ranges whether it contains a key, returning
+// the result for ranges[i] at index i.
+//
+// ranges must be sorted by their lower boundary, else an error wrapping
+// ErrUnsortedInput is returned. They may overlap.
+//
+// As with RangeLookup, there is the possibility of false positives.
+func (surf *SURF) WhichRanges(ranges []Range) ([]bool, error) {
+	out := make([]bool, len(ranges))
+	err := surf.sweepRanges(ranges, func(i int, exists bool) bool {
+		out[i] = exists
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+// sweepRanges answers range lookups for ranges sorted by their lower
+// boundary, calling yield with the index and result of each range. If yield
+// returns false, the sweep is stopped.
+//
+// Rather than looking up each range from the root, a single cursor is moved
+// forward through the trie. It holds the smallest key greater than or equal to
+// the lower boundary of the previous range. As long as this key is not below
+// the current range's lower boundary, it is the one a lookup of the range
+// would find, so the trie need not be touched at all. Otherwise the cursor is
+// moved forward, reusing the path it shares with the range's lower boundary.
+func (surf *SURF) sweepRanges(ranges []Range, yield func(i int, exists bool) bool) error {
+	it := surf.acquireIterator()
+	defer releaseIterator(it)
+
+	// The key the cursor is at, and the error encountered when moving it
+	// there. Once the cursor reached the end of the trie, it stays there.
+	var cursor []byte
+	var cursorErr error
+	positioned := false
+
+	for i, r := range ranges {
+		if i > 0 && louds.Key(r.Low).Less(louds.Key(ranges[i-1].Low)) {
+			return fmt.Errorf(
+				"%w: Range %d starts at %x, before the start %x of its predecessor",
+				ErrUnsortedInput,
+				i,
+				r.Low,
+				ranges[i-1].Low,
+			)
+		}
+
+		if !errors.Is(cursorErr, ErrEndOfTrie) && (!positioned || louds.Key(cursor).Less(louds.Key(r.Low))) {
+			err := it.ascendTo(r.Low)
+			if err != nil {
+				return err
+			}
+
+			cursor, cursorErr = surf.descendOrGreater(it, r.Low)
+			positioned = true
+		}
+
+		exists, err := inRange(cursor, r.High, cursorErr)
+		if err != nil {
+			return err
+		}
+
+		if !yield(i, exists) {
+			return nil
+		}
+	}
+
+	return nil
+}
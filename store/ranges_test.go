@@ -0,0 +1,121 @@
+package store
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/exp/slices"
+)
+
+func TestWhichRanges(t *testing.T) {
+	keySets := [][][]byte{
+		paperKeys(),
+		{
+			[]byte(""),
+			[]byte("a"),
+			[]byte("a\x00"),
+			[]byte("ab"),
+			[]byte("b"),
+		},
+		{},
+	}
+
+	for _, keys := range keySets {
+		surf, err := New(keys, SURFOptions{})
+		if err != nil {
+			t.Fatalf("Error creating SuRF store: %v", err)
+		}
+
+		queries := batchQueries(keys)
+		slices.SortFunc(queries, func(a, b []byte) bool {
+			return bytes.Compare(a, b) < 0
+		})
+
+		// Point ranges, as well as overlapping ones of varying width
+		ranges := make([]Range, 0)
+		for i, low := range queries {
+			ranges = append(ranges, Range{low, low})
+			for j := i + 1; j < len(queries) && j < i+4; j++ {
+				ranges = append(ranges, Range{low, queries[j]})
+			}
+		}
+
+		out, err := surf.WhichRanges(ranges)
+		assert.Nil(t, err)
+		assert.Equal(t, len(ranges), len(out))
+
+		anyExpected := false
+		for i, r := range ranges {
+			exists, err := surf.RangeLookup(r.Low, r.High)
+			assert.Nil(t, err)
+			assert.Equal(t, exists, out[i], "Sweep over range [%q, %q] differs from RangeLookup", r.Low, r.High)
+
+			anyExpected = anyExpected || exists
+		}
+
+		found, err := surf.AnyInRanges(ranges)
+		assert.Nil(t, err)
+		assert.Equal(t, anyExpected, found)
+	}
+}
+
+func TestWhichRangesPaperDataset(t *testing.T) {
+	surf := newPaperSURF(t)
+
+	ranges := []Range{
+		{[]byte("a"), []byte("ezmatch")},
+		{[]byte("fal"), []byte("fat")},
+		{[]byte("fasz"), []byte("fasz")},
+		{[]byte("fb"), []byte("s")},
+		{[]byte("tp"), []byte("tq")},
+		{[]byte("trz"), []byte("zarty")},
+	}
+
+	out, err := surf.WhichRanges(ranges)
+	assert.Nil(t, err)
+	assert.Equal(t, []bool{false, true, false, true, false, false}, out)
+
+	found, err := surf.AnyInRanges(ranges)
+	assert.Nil(t, err)
+	assert.True(t, found)
+
+	found, err = surf.AnyInRanges([]Range{ranges[0], ranges[2], ranges[4], ranges[5]})
+	assert.Nil(t, err)
+	assert.False(t, found)
+
+	found, err = surf.AnyInRanges(nil)
+	assert.Nil(t, err)
+	assert.False(t, found)
+}
+
+func TestWhichRangesUnsorted(t *testing.T) {
+	surf := newPaperSURF(t)
+
+	ranges := []Range{
+		{[]byte("t"), []byte("u")},
+		{[]byte("a"), []byte("b")},
+	}
+
+	_, err := surf.WhichRanges(ranges)
+	assert.True(t, errors.Is(err, ErrUnsortedInput))
+
+	// Ranges without keys are swept past, up to the unsorted one
+	_, err = surf.AnyInRanges([]Range{ranges[1], ranges[1], {[]byte("x"), []byte("y")}, ranges[1]})
+	assert.True(t, errors.Is(err, ErrUnsortedInput))
+}
+
+func BenchmarkWhichRanges(b *testing.B) {
+	keys := benchmarkKeys(10_000)
+	surf, err := New(keys)
+	if err != nil {
+		b.Fatalf("Error creating SuRF store: %v", err)
+	}
+	ranges := benchmarkRanges(keys)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		surf.WhichRanges(ranges)
+	}
+}
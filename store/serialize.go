@@ -0,0 +1,311 @@
+package store
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+
+	"github.com/Lavode/surf/bitmap"
+)
+
+// The serialized format of a SuRF store is as follows:
+//
+//	magic   [4]byte "SuRF"
+//	version uint16
+//	header section
+//	D-Labels section
+//	D-HasChild section
+//	D-IsPrefixKey section
+//
+// Each section consists of:
+//
+//	length   uint64, the number of bytes of the payload
+//	payload  [length]byte
+//	checksum uint32, CRC32C over length and payload
+//
+// The header section's checksum covers the magic and version as well, such
+// that a damaged version is not mistaken for another one.
+//
+// The header's payload holds R, HashBits and RealBits, followed by the
+// BitmapEncoding of D-Labels, D-HasChild and D-IsPrefixKey, and the
+// DenseLayout, as uint64s. The bitmaps' payloads are their plain binary
+// encodings as produced by bitmap.MarshalBinary, whichever their encoding and
+// layout in memory. They are re-encoded when read.
+//
+// SuRF does not store suffixes yet, so there is no section for them.
+//
+// All integers are big-endian.
+
+// formatMagic identifies serialized SuRF stores.
+const formatMagic = "SuRF"
+
+// formatVersion is the version of the serialized format.
+const formatVersion = 1
+
+// headerLength is the length, in bytes, of the header section's payload.
+const headerLength = 7 * 8
+
+// Names of the sections of the serialized format, as used in errors.
+const (
+	sectionHeader           = "header"
+	sectionDenseLabels      = "D-Labels"
+	sectionDenseHasChild    = "D-HasChild"
+	sectionDenseIsPrefixKey = "D-IsPrefixKey"
+)
+
+// ErrUnknownFormat indicates that data is not a serialized SuRF store, or one
+// of an unsupported version.
+var ErrUnknownFormat = errors.New("Unknown SuRF format")
+
+// ErrCorrupted indicates that a serialized SuRF store is damaged, e.g. due to a
+// checksum mismatch or truncation. Errors wrapping it name the damaged section.
+var ErrCorrupted = errors.New("Corrupted SuRF data")
+
+// crcTable is the table of the Castagnoli polynomial, used for CRC32C
+// checksums.
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// WriteTo writes the serialized store to w, returning the number of bytes
+// written.
+//
+// It implements io.WriterTo. The store can be restored with ReadFrom.
+func (surf *SURF) WriteTo(w io.Writer) (int64, error) {
+	var buf bytes.Buffer
+
+	preamble := make([]byte, len(formatMagic)+2)
+	copy(preamble, formatMagic)
+	binary.BigEndian.PutUint16(preamble[len(formatMagic):], formatVersion)
+	buf.Write(preamble)
+
+	header := make([]byte, headerLength)
+	binary.BigEndian.PutUint64(header[0:], uint64(surf.R))
+	binary.BigEndian.PutUint64(header[8:], uint64(surf.HashBits))
+	binary.BigEndian.PutUint64(header[16:], uint64(surf.RealBits))
+	binary.BigEndian.PutUint64(header[24:], uint64(encodingOf(surf.DenseLabels)))
+	binary.BigEndian.PutUint64(header[32:], uint64(encodingOf(surf.DenseHasChild)))
+	binary.BigEndian.PutUint64(header[40:], uint64(encodingOf(surf.DenseIsPrefixKey)))
+	binary.BigEndian.PutUint64(header[48:], uint64(layoutOf(surf.DenseLabels)))
+	writeSection(&buf, preamble, header)
+
+	bitmaps := []struct {
+		name string
+		bm   bitmap.RankSelect
+	}{
+		{sectionDenseLabels, surf.DenseLabels},
+		{sectionDenseHasChild, surf.DenseHasChild},
+		{sectionDenseIsPrefixKey, surf.DenseIsPrefixKey},
+	}
+	for _, b := range bitmaps {
+		bm, err := bitmap.ToBitmap(b.bm)
+		if err != nil {
+			return 0, fmt.Errorf("Error decoding %s: %v", b.name, err)
+		}
+
+		payload, err := bm.MarshalBinary()
+		if err != nil {
+			return 0, fmt.Errorf("Error encoding %s: %v", b.name, err)
+		}
+
+		writeSection(&buf, nil, payload)
+	}
+
+	n, err := w.Write(buf.Bytes())
+	return int64(n), err
+}
+
+// ReadFrom reads a store serialized with WriteTo from r.
+//
+// The checksum of each section is verified. If a section is damaged, an error
+// wrapping ErrCorrupted and naming the section is returned. If the data is not
+// a serialized SuRF store at all, an error wrapping ErrUnknownFormat is
+// returned.
+//
+// Once read, the store's structure is checked with Validate, such that a
+// store which was read successfully is safe to query.
+//
+// ReadFrom is safe to use on untrusted input. It does not panic, and its
+// allocations are bounded by the length of the input actually read, rather
+// than by the sizes declared therein.
+func ReadFrom(r io.Reader) (*SURF, error) {
+	preamble := make([]byte, len(formatMagic)+2)
+	_, err := io.ReadFull(r, preamble)
+	if err != nil {
+		return nil, fmt.Errorf("%w: Error reading magic and version: %v", ErrUnknownFormat, err)
+	}
+
+	if string(preamble[:len(formatMagic)]) != formatMagic {
+		return nil, fmt.Errorf("%w: Invalid magic %x", ErrUnknownFormat, preamble[:len(formatMagic)])
+	}
+
+	version := binary.BigEndian.Uint16(preamble[len(formatMagic):])
+	if version != formatVersion {
+		return nil, fmt.Errorf("%w: Unsupported version %d", ErrUnknownFormat, version)
+	}
+
+	surf := SURF{}
+
+	header, err := readSection(r, sectionHeader, preamble)
+	if err != nil {
+		return nil, err
+	}
+	if len(header) != headerLength {
+		return nil, fmt.Errorf("%w: Section %s has length %d, expected %d", ErrCorrupted, sectionHeader, len(header), headerLength)
+	}
+	ratio := binary.BigEndian.Uint64(header[0:])
+	hashBits := binary.BigEndian.Uint64(header[8:])
+	realBits := binary.BigEndian.Uint64(header[16:])
+	if ratio == 0 || ratio > math.MaxUint32 || hashBits > maxSuffixBits || realBits > maxSuffixBits {
+		return nil, fmt.Errorf(
+			"%w: Section %s has impossible values R = %d, HashBits = %d, RealBits = %d",
+			ErrCorrupted,
+			sectionHeader,
+			ratio,
+			hashBits,
+			realBits,
+		)
+	}
+	surf.R = uint(ratio)
+	surf.HashBits = uint(hashBits)
+	surf.RealBits = uint(realBits)
+
+	encodings := make([]BitmapEncoding, 3)
+	for i := range encodings {
+		enc := binary.BigEndian.Uint64(header[24+8*i:])
+		if enc > math.MaxInt32 || !BitmapEncoding(enc).valid() {
+			return nil, fmt.Errorf("%w: Section %s has unknown bitmap encoding %d", ErrCorrupted, sectionHeader, enc)
+		}
+		encodings[i] = BitmapEncoding(enc)
+	}
+
+	l := binary.BigEndian.Uint64(header[48:])
+	if l > math.MaxInt32 || !DenseLayout(l).valid() {
+		return nil, fmt.Errorf("%w: Section %s has unknown dense layout %d", ErrCorrupted, sectionHeader, l)
+	}
+	layout := DenseLayout(l)
+
+	if layout == LayoutInterleaved && (encodings[0] != EncodingPlain || encodings[1] != EncodingPlain) {
+		return nil, fmt.Errorf("%w: Section %s has dense layout %v with non-plain bitmaps", ErrCorrupted, sectionHeader, layout)
+	}
+
+	bitmaps := []struct {
+		name string
+		enc  BitmapEncoding
+		bm   *bitmap.RankSelect
+	}{
+		{sectionDenseLabels, encodings[0], &surf.DenseLabels},
+		{sectionDenseHasChild, encodings[1], &surf.DenseHasChild},
+		{sectionDenseIsPrefixKey, encodings[2], &surf.DenseIsPrefixKey},
+	}
+	for _, b := range bitmaps {
+		payload, err := readSection(r, b.name, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		bm := &bitmap.Bitmap{}
+		err = bm.UnmarshalBinary(payload)
+		if err != nil {
+			return nil, fmt.Errorf("%w: Section %s: %v", ErrCorrupted, b.name, err)
+		}
+
+		*b.bm = bm
+	}
+
+	// Validating the plain bitmaps is faster, and ensures that the
+	// encoders are only handed sane input.
+	err = surf.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, b := range bitmaps {
+		*b.bm = b.enc.encode((*b.bm).(*bitmap.Bitmap))
+	}
+
+	if layout == LayoutInterleaved {
+		err = surf.interleave()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &surf, nil
+}
+
+// writeSection writes a section with the given payload, prefixed by its length
+// and followed by its checksum, to buf.
+//
+// covered is data preceding the section which the checksum covers as well. It
+// is not written, and may be nil.
+func writeSection(buf *bytes.Buffer, covered, payload []byte) {
+	length := make([]byte, 8)
+	binary.BigEndian.PutUint64(length, uint64(len(payload)))
+
+	checksum := make([]byte, 4)
+	binary.BigEndian.PutUint32(checksum, sectionChecksum(covered, length, payload))
+
+	buf.Write(length)
+	buf.Write(payload)
+	buf.Write(checksum)
+}
+
+// sectionChecksum returns the checksum of a section with the given length and
+// payload, as well as the data preceding it which it covers.
+func sectionChecksum(covered, length, payload []byte) uint32 {
+	crc := crc32.Update(0, crcTable, covered)
+	crc = crc32.Update(crc, crcTable, length)
+	return crc32.Update(crc, crcTable, payload)
+}
+
+// readSection reads a section from r, verifies its checksum and returns its
+// payload.
+//
+// covered is data preceding the section which the checksum covers as well, as
+// passed to writeSection.
+//
+// If the section is truncated or its checksum does not match, an error
+// wrapping ErrCorrupted is returned.
+func readSection(r io.Reader, name string, covered []byte) ([]byte, error) {
+	length := make([]byte, 8)
+	_, err := io.ReadFull(r, length)
+	if err != nil {
+		return nil, fmt.Errorf("%w: Section %s truncated: %v", ErrCorrupted, name, err)
+	}
+	n := binary.BigEndian.Uint64(length)
+	if n > math.MaxInt64 {
+		return nil, fmt.Errorf("%w: Section %s has invalid length %d", ErrCorrupted, name, n)
+	}
+
+	// If the reader knows how much input remains, we can reject oversized
+	// lengths without reading any further.
+	if lr, ok := r.(interface{ Len() int }); ok && n > uint64(lr.Len()) {
+		return nil, fmt.Errorf("%w: Section %s truncated: %d bytes declared, %d remaining", ErrCorrupted, name, n, lr.Len())
+	}
+
+	// Reading through a limited reader, rather than allocating n bytes up
+	// front, ensures that a corrupted length cannot cause a huge
+	// allocation.
+	payload, err := io.ReadAll(io.LimitReader(r, int64(n)))
+	if err != nil {
+		return nil, fmt.Errorf("%w: Section %s truncated: %v", ErrCorrupted, name, err)
+	}
+	if uint64(len(payload)) != n {
+		return nil, fmt.Errorf("%w: Section %s truncated: %d of %d bytes", ErrCorrupted, name, len(payload), n)
+	}
+
+	checksum := make([]byte, 4)
+	_, err = io.ReadFull(r, checksum)
+	if err != nil {
+		return nil, fmt.Errorf("%w: Section %s truncated: %v", ErrCorrupted, name, err)
+	}
+
+	if sectionChecksum(covered, length, payload) != binary.BigEndian.Uint32(checksum) {
+		return nil, fmt.Errorf("%w: Section %s checksum mismatch", ErrCorrupted, name)
+	}
+
+	return payload, nil
+}
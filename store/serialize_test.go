@@ -0,0 +1,259 @@
+package store
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"testing"
+
+	"github.com/Lavode/surf/bitmap"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/exp/slices"
+)
+
+// assertSameBits asserts that two bitmaps, of any encoding, hold the same bits.
+func assertSameBits(t *testing.T, expected, actual bitmap.RankSelect) {
+	expectedBits, err := bitmap.ToBitmap(expected)
+	assert.Nil(t, err)
+	actualBits, err := bitmap.ToBitmap(actual)
+	assert.Nil(t, err)
+
+	assert.True(t, expectedBits.Equal(actualBits))
+}
+
+func TestWriteToReadFrom(t *testing.T) {
+	surf := newPaperSURF(t)
+
+	var buf bytes.Buffer
+	n, err := surf.WriteTo(&buf)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(buf.Len()), n)
+
+	loaded, err := ReadFrom(&buf)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	assert.Equal(t, surf.R, loaded.R)
+	assert.Equal(t, surf.HashBits, loaded.HashBits)
+	assert.Equal(t, surf.RealBits, loaded.RealBits)
+	assertSameBits(t, surf.DenseLabels, loaded.DenseLabels)
+	assertSameBits(t, surf.DenseHasChild, loaded.DenseHasChild)
+	assertSameBits(t, surf.DenseIsPrefixKey, loaded.DenseIsPrefixKey)
+
+	for _, key := range []string{"f", "fas", "fasten", "toy", "trying", "x", "fa"} {
+		expected, err := surf.Lookup([]byte(key))
+		assert.Nil(t, err)
+
+		actual, err := loaded.Lookup([]byte(key))
+		assert.Nil(t, err)
+		assert.Equal(t, expected, actual, "Lookup of %s", key)
+	}
+}
+
+func TestWriteToReadFromEncodings(t *testing.T) {
+	surf, err := New(
+		[][]byte{[]byte("f"), []byte("fas"), []byte("fasten"), []byte("toy"), []byte("trying")},
+		WithHasChildEncoding(EncodingEliasFano),
+		WithIsPrefixKeyEncoding(EncodingEliasFano),
+	)
+	assert.Nil(t, err)
+
+	var buf bytes.Buffer
+	_, err = surf.WriteTo(&buf)
+	assert.Nil(t, err)
+
+	loaded, err := ReadFrom(&buf)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	assert.Equal(t, EncodingPlain, encodingOf(loaded.DenseLabels))
+	assert.Equal(t, EncodingEliasFano, encodingOf(loaded.DenseHasChild))
+	assert.Equal(t, EncodingEliasFano, encodingOf(loaded.DenseIsPrefixKey))
+	assertSameBits(t, surf.DenseHasChild, loaded.DenseHasChild)
+
+	exists, err := loaded.Lookup([]byte("fasten"))
+	assert.Nil(t, err)
+	assert.True(t, exists)
+}
+
+func TestWriteToReadFromInterleaved(t *testing.T) {
+	surf, err := New(
+		[][]byte{[]byte("f"), []byte("fas"), []byte("fasten"), []byte("toy"), []byte("trying")},
+		WithDenseLayout(LayoutInterleaved),
+	)
+	assert.Nil(t, err)
+
+	var buf bytes.Buffer
+	_, err = surf.WriteTo(&buf)
+	assert.Nil(t, err)
+
+	loaded, err := ReadFrom(&buf)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	assert.Equal(t, LayoutInterleaved, layoutOf(loaded.DenseLabels))
+	assertSameBits(t, surf.DenseLabels, loaded.DenseLabels)
+	assertSameBits(t, surf.DenseHasChild, loaded.DenseHasChild)
+
+	exists, err := loaded.Lookup([]byte("fasten"))
+	assert.Nil(t, err)
+	assert.True(t, exists)
+}
+
+func TestReadFromCorrupted(t *testing.T) {
+	var buf bytes.Buffer
+	_, err := newPaperSURF(t).WriteTo(&buf)
+	assert.Nil(t, err)
+	data := buf.Bytes()
+
+	// Flipping any single bit past the preamble must be detected as
+	// corruption.
+	for i := 6; i < len(data); i++ {
+		for bit := 0; bit < 8; bit++ {
+			corrupted := slices.Clone(data)
+			corrupted[i] ^= 1 << bit
+
+			_, err := ReadFrom(bytes.NewReader(corrupted))
+			assert.ErrorIs(t, err, ErrCorrupted, "Flipped bit %d of byte %d", bit, i)
+		}
+	}
+
+	// Bitmaps start after the preamble, and header section of 8 + 56 + 4
+	// bytes.
+	corrupted := slices.Clone(data)
+	corrupted[6+68+8] ^= 0x01
+	_, err = ReadFrom(bytes.NewReader(corrupted))
+	assert.ErrorContains(t, err, "Section D-Labels checksum mismatch")
+
+	// Truncation is detected as well
+	for _, n := range []int{10, 50, len(data) - 1} {
+		_, err := ReadFrom(bytes.NewReader(data[:n]))
+		assert.ErrorIs(t, err, ErrCorrupted, "Truncated to %d bytes", n)
+	}
+}
+
+func TestReadFromUnknownFormat(t *testing.T) {
+	var buf bytes.Buffer
+	_, err := newPaperSURF(t).WriteTo(&buf)
+	assert.Nil(t, err)
+	data := buf.Bytes()
+
+	for i := 0; i < 6; i++ {
+		corrupted := slices.Clone(data)
+		corrupted[i] ^= 0x01
+
+		_, err := ReadFrom(bytes.NewReader(corrupted))
+		assert.ErrorIs(t, err, ErrUnknownFormat)
+	}
+
+	_, err = ReadFrom(bytes.NewReader([]byte("Su")))
+	assert.ErrorIs(t, err, ErrUnknownFormat)
+}
+
+func TestHeaderChecksumCoversPreamble(t *testing.T) {
+	var buf bytes.Buffer
+	_, err := newPaperSURF(t).WriteTo(&buf)
+	assert.Nil(t, err)
+	data := buf.Bytes()
+
+	// A header checksum over the header section alone does not match
+	offset := len(formatMagic) + 2
+	headerEnd := offset + 8 + headerLength
+	binary.BigEndian.PutUint32(data[headerEnd:], sectionChecksum(nil, data[offset:offset+8], data[offset+8:headerEnd]))
+
+	_, err = ReadFrom(bytes.NewReader(data))
+	assert.ErrorIs(t, err, ErrCorrupted)
+	assert.ErrorContains(t, err, "Section header checksum mismatch")
+}
+
+// fixChecksums recomputes the checksums of all sections of a serialized store,
+// such that fuzzing is able to get past them to the decoding of the payloads.
+func fixChecksums(data []byte) []byte {
+	offset := len(formatMagic) + 2
+	if len(data) < offset {
+		return data
+	}
+
+	// The header's checksum covers the preamble
+	start := 0
+	for offset+8+4 <= len(data) {
+		n := binary.BigEndian.Uint64(data[offset:])
+		if n > uint64(len(data)-offset-8-4) {
+			break
+		}
+		end := offset + 8 + int(n)
+
+		crc := crc32.Checksum(data[start:end], crcTable)
+		binary.BigEndian.PutUint32(data[end:], crc)
+
+		offset = end + 4
+		start = offset
+	}
+
+	return data
+}
+
+func FuzzReadFrom(f *testing.F) {
+	keySets := [][][]byte{
+		{},
+		{[]byte("a")},
+		{[]byte("a"), []byte("ab"), []byte("b")},
+		paperKeys(),
+	}
+	for _, keys := range keySets {
+		surf, err := New(keys, SURFOptions{})
+		if err != nil {
+			f.Fatalf("Error creating SuRF store: %v", err)
+		}
+
+		var buf bytes.Buffer
+		_, err = surf.WriteTo(&buf)
+		if err != nil {
+			f.Fatalf("Error serializing SuRF store: %v", err)
+		}
+
+		f.Add(buf.Bytes())
+	}
+	f.Add([]byte(formatMagic))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		for _, input := range [][]byte{data, fixChecksums(slices.Clone(data))} {
+			surf, err := ReadFrom(bytes.NewReader(input))
+			if err != nil {
+				continue
+			}
+
+			// A store which was read successfully must be safe to
+			// query.
+			for _, key := range [][]byte{{}, []byte("a"), []byte("fas"), {0xFF, 0x00}} {
+				_, err := surf.Lookup(key)
+				if err != nil {
+					t.Errorf("Error looking up key %x: %v", key, err)
+				}
+			}
+
+			_, err = surf.Count([]byte{}, []byte{0xFF, 0xFF, 0xFF, 0xFF})
+			if err != nil {
+				t.Errorf("Error counting keys: %v", err)
+			}
+
+			_, err = surf.Stats()
+			if err != nil {
+				t.Errorf("Error computing stats: %v", err)
+			}
+
+			// And serialize to the very same data
+			var buf bytes.Buffer
+			_, err = surf.WriteTo(&buf)
+			if err != nil {
+				t.Errorf("Error serializing SuRF store: %v", err)
+			}
+			if !bytes.HasPrefix(input, buf.Bytes()) {
+				t.Errorf("Serialized store differs from its input")
+			}
+		}
+	})
+}
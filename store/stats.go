@@ -0,0 +1,136 @@
+package store
+
+import (
+	"fmt"
+
+	"github.com/Lavode/surf/bitmap"
+	"github.com/Lavode/surf/louds/dense"
+)
+
+// Stats describes the structure and space usage of a SuRF store.
+type Stats struct {
+	// Keys is the number of (truncated) keys stored.
+	Keys int
+
+	// Nodes is the total number of nodes of the trie.
+	Nodes int
+	// NodesPerLevel holds the number of nodes on each level of the trie,
+	// starting with the root level.
+	NodesPerLevel []int
+	// Height is the number of levels of the trie.
+	Height int
+	// DenseLevels is the number of levels, counted from the root, which are
+	// LOUDS-DENSE encoded. It is thus the level at which the encoding would
+	// switch to LOUDS-SPARSE.
+	//
+	// As LOUDS-SPARSE is not implemented yet, this currently always equals
+	// Height.
+	DenseLevels int
+
+	// Size holds the number of bits used by each component, in its
+	// encoding and layout. It equals the size returned by EstimateSize for
+	// the store's keys and options.
+	Size Size
+	// BitsPerKey is the number of bits used per stored key. It is 0 if no
+	// keys are stored.
+	BitsPerKey float64
+
+	// FanOut is a histogram of the nodes' number of outbound edges. That
+	// is, FanOut[n] is the number of nodes with exactly n outbound edges.
+	// It has 257 entries, for fan-outs of 0 through 256.
+	FanOut []int
+}
+
+// Stats computes statistics about the structure and space usage of the store.
+//
+// The whole encoding is traversed, so this is not a cheap operation.
+func (surf *SURF) Stats() (Stats, error) {
+	stats := Stats{
+		NodesPerLevel: make([]int, 0),
+		FanOut:        make([]int, 257),
+	}
+
+	leaves := 0
+
+	// Nodes are stored in level order, so the nodes of each level follow
+	// directly upon the ones of the previous level. The number of nodes
+	// on the next level is the number of edges with children on the
+	// current one.
+	levelStart, levelEnd := 0, 1
+	for levelStart < levelEnd {
+		children := 0
+
+		for node := levelStart; node < levelEnd; node++ {
+			fanOut := 0
+
+			// Rather than probing each possible edge, we scan for
+			// the ones which exist.
+			nodeStart := 256 * node
+			for from := nodeStart; ; {
+				label, found, err := bitmap.NextOne(surf.DenseLabels, from)
+				if err != nil {
+					return Stats{}, fmt.Errorf("Error finding next 1-bit of D-Labels from %d: %v", from, err)
+				}
+				if !found || label >= nodeStart+256 {
+					break
+				}
+				from = label + 1
+				fanOut++
+
+				hasChild, err := surf.DenseHasChild.Get(label)
+				if err != nil {
+					return Stats{}, fmt.Errorf("Error accessing bit %d of D-HasChild: %v", label, err)
+				}
+
+				if hasChild == 1 {
+					children++
+				} else {
+					leaves++
+				}
+			}
+
+			isPrefixKey, err := surf.DenseIsPrefixKey.Get(node)
+			if err != nil {
+				return Stats{}, fmt.Errorf("Error accessing bit %d of D-IsPrefixKey: %v", node, err)
+			}
+
+			stats.Keys += int(isPrefixKey)
+			stats.FanOut[fanOut]++
+		}
+
+		stats.NodesPerLevel = append(stats.NodesPerLevel, levelEnd-levelStart)
+		levelStart, levelEnd = levelEnd, levelEnd+children
+	}
+
+	stats.Keys += leaves
+	stats.Nodes = levelEnd
+	stats.Height = len(stats.NodesPerLevel)
+	stats.DenseLevels = stats.Height
+
+	stats.Size = Size{
+		DenseLabels:      sizeBits(surf.DenseLabels, 256*stats.Nodes),
+		DenseHasChild:    sizeBits(surf.DenseHasChild, 256*stats.Nodes),
+		DenseIsPrefixKey: sizeBits(surf.DenseIsPrefixKey, stats.Nodes),
+	}
+
+	if stats.Keys > 0 {
+		stats.BitsPerKey = float64(stats.Size.Total()) / float64(stats.Keys)
+	}
+
+	return stats, nil
+}
+
+// sizeBits returns the number of bits used by the bitmap rs, which holds
+// plainBits bits when stored as a plain bitmap.
+func sizeBits(rs bitmap.RankSelect, plainBits int) int {
+	switch rs := rs.(type) {
+	case *bitmap.EliasFano:
+		return rs.SizeBits()
+	case *dense.InterleavedBitmap:
+		// The nodes' blocks are split evenly between D-Labels and
+		// D-HasChild, as by EstimateSize.
+		return rs.Layout().SizeBits() / 2
+	default:
+		return plainBits
+	}
+}
@@ -0,0 +1,91 @@
+package store
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStats(t *testing.T) {
+	surf := newPaperSURF(t)
+
+	stats, err := surf.Stats()
+	assert.Nil(t, err)
+
+	assert.Equal(t, 11, stats.Keys)
+	assert.Equal(t, 8, stats.Nodes)
+	assert.Equal(t, []int{1, 2, 3, 2}, stats.NodesPerLevel)
+	assert.Equal(t, 4, stats.Height)
+	assert.Equal(t, 4, stats.DenseLevels)
+
+	size, err := EstimateSize(paperKeys())
+	assert.Nil(t, err)
+	assert.Equal(t, size, stats.Size)
+	assert.Equal(t, float64(8*513)/11, stats.BitsPerKey)
+
+	expectedFanOut := make([]int, 257)
+	expectedFanOut[1] = 2 // f, fas
+	expectedFanOut[2] = 4 // t, to, tr, tri
+	expectedFanOut[3] = 2 // root, fa
+	assert.Equal(t, expectedFanOut, stats.FanOut)
+}
+
+func TestStatsSize(t *testing.T) {
+	keys := benchmarkKeys(2000)
+
+	configs := [][]Option{
+		{},
+		{WithLabelsEncoding(EncodingEliasFano)},
+		{WithHasChildEncoding(EncodingEliasFano), WithIsPrefixKeyEncoding(EncodingEliasFano)},
+		{WithDenseLayout(LayoutInterleaved)},
+		{WithDenseLayout(LayoutInterleaved), WithIsPrefixKeyEncoding(EncodingEliasFano)},
+	}
+
+	for _, opts := range configs {
+		surf, err := New(keys, opts...)
+		assert.Nil(t, err)
+
+		stats, err := surf.Stats()
+		assert.Nil(t, err)
+
+		size, err := EstimateSize(keys, opts...)
+		assert.Nil(t, err)
+		assert.Equal(t, size, stats.Size)
+		assert.Equal(t, float64(size.Total())/float64(stats.Keys), stats.BitsPerKey)
+	}
+
+	// Elias-Fano shrinks the sparse D-HasChild, interleaving grows the
+	// nodes.
+	plain, err := New(keys)
+	assert.Nil(t, err)
+	plainStats, err := plain.Stats()
+	assert.Nil(t, err)
+
+	surf, err := New(keys, WithHasChildEncoding(EncodingEliasFano))
+	assert.Nil(t, err)
+	stats, err := surf.Stats()
+	assert.Nil(t, err)
+	assert.Less(t, stats.Size.DenseHasChild, plainStats.Size.DenseHasChild)
+
+	surf, err = New(keys, WithDenseLayout(LayoutInterleaved))
+	assert.Nil(t, err)
+	stats, err = surf.Stats()
+	assert.Nil(t, err)
+	assert.Equal(t, 2*plainStats.Size.DenseLabels, stats.Size.DenseLabels)
+}
+
+func TestStatsEmpty(t *testing.T) {
+	surf, err := New([][]byte{}, SURFOptions{})
+	if err != nil {
+		t.Fatalf("Error creating SuRF store: %v", err)
+	}
+
+	stats, err := surf.Stats()
+	assert.Nil(t, err)
+
+	assert.Equal(t, 0, stats.Keys)
+	assert.Equal(t, 1, stats.Nodes)
+	assert.Equal(t, []int{1}, stats.NodesPerLevel)
+	assert.Equal(t, float64(0), stats.BitsPerKey)
+	assert.Equal(t, 1, stats.FanOut[0])
+}
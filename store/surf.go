@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"sync"
 
 	"github.com/Lavode/surf/bitmap"
 	"github.com/Lavode/surf/louds"
@@ -11,6 +12,28 @@ import (
 	"golang.org/x/exp/slices"
 )
 
+// ErrUnsortedInput indicates that keys or ranges were not sorted.
+//
+// New sorts the keys it is given, so never returns it. AnyInRanges and
+// WhichRanges return an error wrapping it if their ranges are not sorted by
+// their lower boundary, as do the underlying builders if used directly with
+// unsorted keys.
+var ErrUnsortedInput = louds.ErrUnsortedInput
+
+// ErrDuplicateKey indicates that a key was passed to New more than once.
+var ErrDuplicateKey = louds.ErrDuplicateKey
+
+// ErrMemoryLimitExceeded indicates that the keys passed to New could not be
+// encoded within the configured memory limit.
+//
+// Errors wrapping it are of type *MemoryLimitError, which specifies the number
+// of required and available bits.
+var ErrMemoryLimitExceeded = louds.ErrMemoryLimitExceeded
+
+// MemoryLimitError is returned by New if encoding the keys would exceed the
+// configured memory limit.
+type MemoryLimitError = louds.MemoryLimitError
+
 type SURF struct {
 	// R defines the ratio between sparse and dense LOUDS encodings.
 	// Check SURFOption for details.
@@ -30,27 +53,204 @@ type SURF struct {
 
 	// DenseLabels is the D-Labels bitmap of the LOUDS-DENSE encoding.
 	// Bits are set corresponding to the outbound edges of a node.
-	DenseLabels *bitmap.Bitmap
+	//
+	// Like the other bitmaps, it may be stored in any of the encodings of
+	// BitmapEncoding, as configured by the options.
+	DenseLabels bitmap.RankSelect
 	// DenseHasChild is the D-HasChild bitmap of the LOUDS-DENSE encoding.
 	// Bits are set if the thing pointed to by the edge is an FST
 	// sub-component.
-	DenseHasChild *bitmap.Bitmap
+	DenseHasChild bitmap.RankSelect
 	// DenseIsPrefixKey is the D-IsPrefixKey bitmap of the LOUDS-DENSE
 	// encoding.
 	// Bits are set if the prefix leading up to a node is also a stored
 	// key.
-	DenseIsPrefixKey *bitmap.Bitmap
+	DenseIsPrefixKey bitmap.RankSelect
 }
 
-func New(rawKeys [][]byte, options SURFOptions) (*SURF, error) {
+// New builds a SuRF store from the given keys.
+//
+// The keys need not be sorted, but must not contain duplicates. If they do, an
+// error wrapping ErrDuplicateKey is returned. The empty key, as well as keys
+// which are prefixes of each other, are supported.
+//
+// Options may be passed either as a SURFOptions struct, or using the With*
+// functions, e.g.:
+//
+//	store.New(keys, store.WithR(64), store.WithHashBits(8))
+//
+// If they are invalid, an error wrapping ErrInvalidOption is returned.
+//
+// If the keys cannot be encoded within the configured memory limit, an error
+// wrapping a *MemoryLimitError is returned.
+func New(rawKeys [][]byte, opts ...Option) (*SURF, error) {
 	surf := SURF{}
 
-	options.setDefaults()
+	options, err := newOptions(opts...)
+	if err != nil {
+		return nil, err
+	}
 
 	surf.R = *options.R
 	surf.HashBits = *options.HashBits
 	surf.RealBits = *options.RealBits
 
+	keys, err := prepareKeys(rawKeys)
+	if err != nil {
+		return nil, err
+	}
+
+	// Knowing the exact size of each encoding up front allows us to check
+	// the memory limit once, before building anything, and to hand each
+	// builder precisely the memory it needs.
+	plain := plainSize(keys)
+	required := peakSize(plain, estimateSize(keys, plain, options), options)
+	if required > *options.MemoryLimit {
+		return nil, &MemoryLimitError{Required: required, Available: *options.MemoryLimit}
+	}
+
+	// TODO once LOUDS-SPARSE support added, the dense builder must only be
+	// handed the keys' dense levels (based on options.R).
+	denseBuilder := dense.NewBuilder(plain.Dense())
+	err = denseBuilder.Build(keys)
+	if err != nil {
+		return nil, fmt.Errorf("Error building LOUDS-DENSE representation: %w", err)
+	}
+
+	surf.DenseLabels = options.LabelsEncoding.encode(denseBuilder.Labels)
+	surf.DenseHasChild = options.HasChildEncoding.encode(denseBuilder.HasChild)
+	surf.DenseIsPrefixKey = options.IsPrefixKeyEncoding.encode(denseBuilder.IsPrefixKey)
+
+	if *options.DenseLayout == LayoutInterleaved {
+		err = surf.interleave()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &surf, nil
+}
+
+// interleave switches the D-Labels and D-HasChild bitmaps to the interleaved
+// layout.
+func (surf *SURF) interleave() error {
+	il, err := dense.NewInterleaved(surf.DenseLabels, surf.DenseHasChild)
+	if err != nil {
+		return fmt.Errorf("Error building interleaved LOUDS-DENSE layout: %w", err)
+	}
+
+	surf.DenseLabels = il.Labels()
+	surf.DenseHasChild = il.HasChild()
+
+	return nil
+}
+
+// Size specifies the number of bits needed by each component of a SuRF store.
+type Size struct {
+	// DenseLabels is the size of the D-Labels bitmap.
+	DenseLabels int
+	// DenseHasChild is the size of the D-HasChild bitmap.
+	DenseHasChild int
+	// DenseIsPrefixKey is the size of the D-IsPrefixKey bitmap.
+	DenseIsPrefixKey int
+}
+
+// Dense returns the number of bits needed by the LOUDS-DENSE encoding.
+func (size Size) Dense() int {
+	return size.DenseLabels + size.DenseHasChild + size.DenseIsPrefixKey
+}
+
+// Total returns the number of bits needed by all components.
+func (size Size) Total() int {
+	return size.Dense()
+}
+
+// EstimateSize returns the exact number of bits a SuRF store built from the
+// given keys and options would need, without building it.
+//
+// The size of each component depends on its encoding and on the dense layout.
+// Memory which does not hold encoded bits, such as the padding of bitmaps to a
+// multiple of 64 bits and the rank indexes of plain bitmaps, is not included.
+//
+// While building the store, New needs more memory than this, as detailed for
+// SURFOptions.MemoryLimit. The same errors as for New are returned for invalid
+// keys or options.
+func EstimateSize(rawKeys [][]byte, opts ...Option) (Size, error) {
+	options, err := newOptions(opts...)
+	if err != nil {
+		return Size{}, err
+	}
+
+	keys, err := prepareKeys(rawKeys)
+	if err != nil {
+		return Size{}, err
+	}
+
+	return estimateSize(keys, plainSize(keys), options), nil
+}
+
+// plainSize returns the number of bits needed to store the given keys, which
+// must have been prepared with prepareKeys, in plain bitmaps with the separate
+// dense layout. This is the form in which the store is built.
+func plainSize(keys []louds.Key) Size {
+	labels, hasChild, isPrefixKey := dense.EncodedSize(keys)
+
+	return Size{
+		DenseLabels:      labels,
+		DenseHasChild:    hasChild,
+		DenseIsPrefixKey: isPrefixKey,
+	}
+}
+
+// estimateSize returns the number of bits needed to store the given keys,
+// which must have been prepared with prepareKeys, with the encodings and
+// layout of options. plain is their size in plain form, as returned by
+// plainSize.
+func estimateSize(keys []louds.Key, plain Size, options SURFOptions) Size {
+	labelsOnes, hasChildOnes, isPrefixKeyOnes := dense.EncodedOnes(keys)
+
+	size := Size{
+		DenseLabels:      options.LabelsEncoding.sizeBits(plain.DenseLabels, labelsOnes),
+		DenseHasChild:    options.HasChildEncoding.sizeBits(plain.DenseHasChild, hasChildOnes),
+		DenseIsPrefixKey: options.IsPrefixKeyEncoding.sizeBits(plain.DenseIsPrefixKey, isPrefixKeyOnes),
+	}
+
+	if *options.DenseLayout == LayoutInterleaved {
+		// D-IsPrefixKey holds one bit per node. The nodes' blocks are
+		// split evenly between D-Labels and D-HasChild.
+		size.DenseLabels = dense.NodeBlockBits / 2 * plain.DenseIsPrefixKey
+		size.DenseHasChild = size.DenseLabels
+	}
+
+	return size
+}
+
+// peakSize returns the number of bits New needs at most while building a
+// store of the given plain size, as returned by plainSize, and of the given
+// size with the encodings and layout of options, as returned by estimateSize.
+//
+// The store is built in plain bitmaps, from which all other encodings and the
+// interleaved layout are derived, so these are needed on top of the plain
+// ones.
+func peakSize(plain, size Size, options SURFOptions) int {
+	peak := plain.Total()
+
+	if *options.DenseLayout == LayoutInterleaved || *options.LabelsEncoding != EncodingPlain {
+		peak += size.DenseLabels
+	}
+	if *options.DenseLayout == LayoutInterleaved || *options.HasChildEncoding != EncodingPlain {
+		peak += size.DenseHasChild
+	}
+	if *options.IsPrefixKeyEncoding != EncodingPlain {
+		peak += size.DenseIsPrefixKey
+	}
+
+	return peak
+}
+
+// prepareKeys converts raw keys to sorted, validated and truncated LOUDS keys,
+// ready to be passed to the builders.
+func prepareKeys(rawKeys [][]byte) ([]louds.Key, error) {
 	// TODO this can't be the proper way, surely :)
 	keys := make([]louds.Key, len(rawKeys))
 	for i := 0; i < len(rawKeys); i++ {
@@ -63,22 +263,14 @@ func New(rawKeys [][]byte, options SURFOptions) (*SURF, error) {
 	}
 	slices.SortFunc(keys, sort)
 
-	// Truncate keys
-	keys = louds.Truncate(keys)
-
-	// TODO once LOUDS-SPARSE support added, memory limit must be split
-	// appropriately (based on options.R) between DENSE and SPARSE builder.
-	denseBuilder := dense.NewBuilder(*options.MemoryLimit)
-	err := denseBuilder.Build(keys)
+	// Truncation relies on keys being unique, so we'll check them before
+	// truncating.
+	err := louds.ValidateKeys(keys)
 	if err != nil {
-		return nil, fmt.Errorf("Error building LOUDS-DENSE representation: %v", err)
+		return nil, err
 	}
 
-	surf.DenseLabels = denseBuilder.Labels
-	surf.DenseHasChild = denseBuilder.HasChild
-	surf.DenseIsPrefixKey = denseBuilder.IsPrefixKey
-
-	return &surf, nil
+	return louds.Truncate(keys), nil
 }
 
 // Lookup checks existence of a key in the SuRF store.
@@ -88,48 +280,92 @@ func New(rawKeys [][]byte, options SURFOptions) (*SURF, error) {
 // keys, as well as the number of additional bits used to store full keys
 // (RealBits) and the hash value of keys (KeyBits).
 func (surf *SURF) Lookup(key []byte) (bool, error) {
-	exists, _, _, err := surf.lookup(key)
+	it := surf.acquireIterator()
+	defer releaseIterator(it)
+
+	exists, _, err := surf.descend(it, key)
 
 	return exists, err
 }
 
-// lookup checks existence of a key in the SuRF store (see documentation of
-// Lookup).
+// iteratorPool holds iterators for reuse by lookups, such that the buffers
+// backing their stacks need not be allocated anew for each lookup.
+var iteratorPool = sync.Pool{
+	New: func() interface{} {
+		return &Iterator{}
+	},
+}
+
+// acquireIterator returns an iterator at the root node of the store, which
+// must be returned with releaseIterator once done.
 //
-// In addition it will yield the actual key which was found as well as the
-// iterator in whatever state it was then lookup terminated.
-// This can be used to e.g. implement find-next-greater-than.
+// Its visitedRoot flag is set, as lookups start out by considering the empty
+// key, so the iterator can be used with descend right away.
+func (surf *SURF) acquireIterator() *Iterator {
+	it := iteratorPool.Get().(*Iterator)
+	it.reset()
+
+	it.Labels = surf.DenseLabels
+	it.HasChild = surf.DenseHasChild
+	it.IsPrefixKey = surf.DenseIsPrefixKey
+	it.visitedRoot = true
+
+	return it
+}
+
+// releaseIterator returns an iterator obtained by acquireIterator to the pool.
+// Neither it, nor keys it returned, may be used afterwards.
+//
+// The iterator's bitmaps are cleared, such that the pool does not keep the
+// store they belong to alive.
+func releaseIterator(it *Iterator) {
+	it.Labels = nil
+	it.HasChild = nil
+	it.IsPrefixKey = nil
+
+	iteratorPool.Put(it)
+}
+
+// descend moves the iterator down along key, starting at its current node,
+// and checks whether key exists (see documentation of Lookup). The iterator's
+// current node must be the one reached by the first it.Depth() bytes of key.
+//
+// It returns whether key exists and, if so, the length of the matched
+// (potentially truncated) key.
 //
 // As this leaks internal structure this method is not part of the public API.
 //
-// If there was a match, then the iterator's nextEdge will be the edge *where
-// the match was found*. THis implies that calling Next() on it will produce
-// the same element a second time.
+// Whether there was a match or not, calling Next() on the iterator will produce
+// the next key greater than the matched respectively looked-up key.
+// If the match was on a leaf, nextEdge is advanced past the edge where the
+// match was found. If the match was on a prefix key, the iterator points to
+// the node of said key, all of whose children are greater.
 // If there was no match, nextEdge will be the first edge where it was clear
 // that no match will be found.
 // This is rather messy and in need of refactoring, see the comment at the top
 // of iterator.go.
-func (surf *SURF) lookup(key []byte) (bool, []byte, Iterator, error) {
-	it := Iterator{
-		Labels:      surf.DenseLabels,
-		HasChild:    surf.DenseHasChild,
-		IsPrefixKey: surf.DenseIsPrefixKey,
-	}
+func (surf *SURF) descend(it *Iterator, key []byte) (bool, int, error) {
+	// We might be re-entering a node which was visited before, so none of
+	// its edges have been visited yet.
+	it.nextEdge = 0
 
-	for i := 0; i < len(key); i++ {
+	for i := it.Depth(); i < len(key); i++ {
 		keyByte := key[i]
 
 		err := it.GoToChild(keyByte)
 		if err != nil {
 			if errors.Is(err, ErrNoSuchEdge) {
 				// No edge with this value, so the key doesn't exist.
-				return false, []byte{}, it, nil
+				return false, 0, nil
 			} else if errors.Is(err, ErrIsLeaf) {
-				// We attempted to enter a leaf node, so the key exists
-				return true, key[:i+1], it, nil
+				// We attempted to enter a leaf node, so the key
+				// exists. We advance past its edge, to not
+				// yield it a second time.
+				it.nextEdge++
+				return true, i + 1, nil
 			} else {
 				// Non-specific error, e.g. issue with bitmap access
-				return false, []byte{}, it, err
+				return false, 0, err
 			}
 		}
 	}
@@ -139,54 +375,86 @@ func (surf *SURF) lookup(key []byte) (bool, []byte, Iterator, error) {
 	// IsPrefixKey set to true.
 	isPrefixKey, err := surf.DenseIsPrefixKey.Get(it.NodeIndex)
 	if err != nil {
-		return false, []byte{}, it, fmt.Errorf("Error accessing bit %d in D-IsPrefixKey: %v", it.NodeIndex, err)
+		return false, 0, fmt.Errorf("Error accessing bit %d in D-IsPrefixKey: %v", it.NodeIndex, err)
 	}
 
 	if isPrefixKey == 1 {
-		return true, key, it, nil
+		return true, len(key), nil
 	} else {
-		return false, []byte{}, it, nil
+		return false, 0, nil
 	}
 }
 
-// lookupOrGreater checks existence of a key in the SuRF store.
+// descendOrGreater checks existence of a key in the SuRF store, starting at
+// the iterator's current node, as descend does.
 //
 // If it is found it is returned. If not, then the next greater key is
-// returned.
-//
-// It also returns the matching key, as well as the state of the iterator at
-// that point.
+// returned, leaving the iterator at that key. The returned key is only valid
+// until the iterator is next moved.
 //
 // If no greater key is found, ErrEndOfTrie is returned.
-func (surf *SURF) lookupOrGreater(key []byte) ([]byte, Iterator, error) {
-	exists, matchedKey, it, err := surf.lookup(key)
+func (surf *SURF) descendOrGreater(it *Iterator, key []byte) ([]byte, error) {
+	exists, matched, err := surf.descend(it, key)
 	if err != nil {
-		return []byte{}, it, err
+		return []byte{}, err
 	}
 
 	if exists {
-		// The iterator currently points to the exact match. We'll
-		// advance its nextEdge field by one, to ensure that a call to
-		// Next() won't produce the element a second time.
-		// This is in need of cleaning up, see the comment in
-		// iterator.go.
-		it.nextEdge++
-
 		// We won't return `key` but rather the (potentially truncated)
 		// key stored in the FST.
-		return matchedKey, it, nil
+		return key[:matched], nil
 	} else {
 		// We can easily find the next larger key by telling the
 		// iterator to find the next key from where it is at currently.
-		largerKey, err := it.Next()
-		if errors.Is(err, ErrEndOfTrie) {
-			return []byte{}, it, err
+		largerKey, err := it.next()
+		if err != nil {
+			return []byte{}, err
+		}
+
+		return largerKey, nil
+	}
+}
+
+// HasPrefix checks whether the store contains a key starting with the given
+// prefix.
+//
+// As with Lookup, there is the possibility of false positives. In particular,
+// if a stored key was truncated to a prefix of the queried prefix, it is
+// considered a match.
+func (surf *SURF) HasPrefix(prefix []byte) (bool, error) {
+	it := Iterator{
+		Labels:      surf.DenseLabels,
+		HasChild:    surf.DenseHasChild,
+		IsPrefixKey: surf.DenseIsPrefixKey,
+	}
+
+	for _, b := range prefix {
+		err := it.GoToChild(b)
+		if errors.Is(err, ErrNoSuchEdge) {
+			return false, nil
+		} else if errors.Is(err, ErrIsLeaf) {
+			// A (truncated) key is a prefix of the queried
+			// prefix.
+			return true, nil
 		} else if err != nil {
-			return []byte{}, it, err
+			return false, err
 		}
+	}
 
-		return largerKey, it, nil
+	// Each node other than the root has keys below it. The root only has
+	// if the store is not empty.
+	if it.NodeIndex != 0 {
+		return true, nil
+	}
+
+	_, err := it.Next()
+	if errors.Is(err, ErrEndOfTrie) {
+		return false, nil
+	} else if err != nil {
+		return false, err
 	}
+
+	return true, nil
 }
 
 // RangeLookup checks the existence of a key in the [low, high] range,
@@ -194,7 +462,16 @@ func (surf *SURF) lookupOrGreater(key []byte) ([]byte, Iterator, error) {
 //
 // As with Lookup, there is the possibility of false positives.
 func (surf *SURF) RangeLookup(low, high []byte) (bool, error) {
-	matchedKey, _, err := surf.lookupOrGreater(low)
+	it := surf.acquireIterator()
+	defer releaseIterator(it)
+
+	matchedKey, err := surf.descendOrGreater(it, low)
+	return inRange(matchedKey, high, err)
+}
+
+// inRange interprets the result of a descendOrGreater call as the answer to a
+// range lookup with upper boundary high.
+func inRange(matchedKey, high []byte, err error) (bool, error) {
 	if errors.Is(err, ErrEndOfTrie) {
 		return false, nil
 	} else if err != nil {
@@ -214,7 +491,10 @@ func (surf *SURF) RangeLookup(low, high []byte) (bool, error) {
 // The count is exact, except for the two boundary cases. As such there is the
 // possibility to overcount by up to two.
 func (surf *SURF) Count(low, high []byte) (int, error) {
-	matchedKey, it, err := surf.lookupOrGreater(low)
+	it := surf.acquireIterator()
+	defer releaseIterator(it)
+
+	matchedKey, err := surf.descendOrGreater(it, low)
 	if errors.Is(err, ErrEndOfTrie) {
 		return 0, nil
 	} else if err != nil {
@@ -227,11 +507,13 @@ func (surf *SURF) Count(low, high []byte) (int, error) {
 	for curKey.Less(highKey) || bytes.Equal(curKey, highKey) {
 		count++
 
-		nextKey, err := it.Next()
+		// The key is only compared before the iterator is moved
+		// again, so need not be copied.
+		nextKey, err := it.next()
 		if errors.Is(err, ErrEndOfTrie) {
 			break
 		} else if err != nil {
-			return 0, nil
+			return 0, err
 		}
 
 		curKey = louds.Key(nextKey)
@@ -239,3 +521,74 @@ func (surf *SURF) Count(low, high []byte) (int, error) {
 
 	return count, nil
 }
+
+// Range is a range of keys [Low, High], including boundaries.
+type Range struct {
+	Low  []byte
+	High []byte
+}
+
+// LookupBatch checks existence of each of keys in the SuRF store, storing
+// whether keys[i] exists in out[i].
+//
+// Rather than starting each lookup at the root, the path shared with the
+// previous key is reused. As such it is most efficient on sorted keys, but
+// gives the same answers as Lookup for keys in any order.
+//
+// An error is returned if out is shorter than keys.
+func (surf *SURF) LookupBatch(keys [][]byte, out []bool) error {
+	if len(out) < len(keys) {
+		return fmt.Errorf("Output of length %d cannot hold results of %d keys", len(out), len(keys))
+	}
+
+	it := surf.acquireIterator()
+	defer releaseIterator(it)
+
+	for i, key := range keys {
+		err := it.ascendTo(key)
+		if err != nil {
+			return err
+		}
+
+		exists, _, err := surf.descend(it, key)
+		if err != nil {
+			return err
+		}
+		out[i] = exists
+	}
+
+	return nil
+}
+
+// RangeLookupBatch checks the existence of a key in each of ranges, storing
+// the result for ranges[i] in out[i].
+//
+// As with LookupBatch, the path shared with the previous range's lower
+// boundary is reused, so it is most efficient on sorted ranges, but gives the
+// same answers as RangeLookup for ranges in any order.
+//
+// An error is returned if out is shorter than ranges.
+func (surf *SURF) RangeLookupBatch(ranges []Range, out []bool) error {
+	if len(out) < len(ranges) {
+		return fmt.Errorf("Output of length %d cannot hold results of %d ranges", len(out), len(ranges))
+	}
+
+	it := surf.acquireIterator()
+	defer releaseIterator(it)
+
+	for i, r := range ranges {
+		err := it.ascendTo(r.Low)
+		if err != nil {
+			return err
+		}
+
+		matchedKey, err := surf.descendOrGreater(it, r.Low)
+		exists, err := inRange(matchedKey, r.High, err)
+		if err != nil {
+			return err
+		}
+		out[i] = exists
+	}
+
+	return nil
+}
@@ -1,10 +1,14 @@
 package store
 
 import (
+	"bytes"
 	"math/rand"
 	"testing"
 
+	"github.com/Lavode/surf/bitmap"
+	"github.com/Lavode/surf/louds"
 	"github.com/stretchr/testify/assert"
+	"golang.org/x/exp/slices"
 )
 
 func TestNew(t *testing.T) {
@@ -75,6 +79,34 @@ func TestLookup(t *testing.T) {
 	}
 }
 
+// paperKeys returns the keys of the example dataset from the SuRF paper, in
+// no particular order. Their truncated forms are encoded in 8 nodes.
+func paperKeys() [][]byte {
+	return [][]byte{
+		[]byte("farther"),
+		[]byte("tries"),
+		[]byte("fat"),
+		[]byte("trying"),
+		[]byte("fasten"),
+		[]byte("topper"),
+		[]byte("f"),
+		[]byte("splice"),
+		[]byte("tripper"),
+		[]byte("toy"),
+		[]byte("fas"),
+	}
+}
+
+// newPaperSURF returns a store of the keys returned by paperKeys.
+func newPaperSURF(t *testing.T) *SURF {
+	surf, err := New(paperKeys(), SURFOptions{})
+	if err != nil {
+		t.Fatalf("Error creating SuRF store: %v", err)
+	}
+
+	return surf
+}
+
 // Performs a test with the test-data-set pulled from the paper.
 func TestLookupPaperTestset(t *testing.T) {
 	keys := [][]byte{
@@ -176,13 +208,21 @@ func TestLookupRandom(t *testing.T) {
 
 	rand.Seed(42)
 
-	keys := make([][]byte, NUM_KEYS)
-	for i := 0; i < NUM_KEYS; i++ {
+	// Duplicate keys are rejected, so we must ensure they are unique.
+	seen := make(map[string]bool, NUM_KEYS)
+	keys := make([][]byte, 0, NUM_KEYS)
+	for len(keys) < NUM_KEYS {
 		keyLength := rand.Intn(KEY_LENGTH_MAX - KEY_LENGTH_MIN + 1) // [0, max - min + 1)
 		keyLength += KEY_LENGTH_MIN                                 // [min, max + 1)
 
-		keys[i] = make([]byte, keyLength)
-		rand.Read(keys[i])
+		key := make([]byte, keyLength)
+		rand.Read(key)
+
+		if seen[string(key)] {
+			continue
+		}
+		seen[string(key)] = true
+		keys = append(keys, key)
 	}
 
 	surf, err := New(keys, SURFOptions{})
@@ -238,13 +278,17 @@ func TestLookupOrGreater(t *testing.T) {
 	}
 
 	for _, test := range tests {
-		key, _, err := surf.lookupOrGreater(test.query)
+		it := surf.acquireIterator()
+		key, err := surf.descendOrGreater(it, test.query)
 		assert.Nil(t, err)
 		assert.Equal(t, test.result, key)
+		releaseIterator(it)
 	}
 
 	// No next-larger key should be found
-	_, _, err = surf.lookupOrGreater([]byte("trz"))
+	it := surf.acquireIterator()
+	defer releaseIterator(it)
+	_, err = surf.descendOrGreater(it, []byte("trz"))
 	assert.NotNil(t, err)
 	assert.ErrorIs(t, err, ErrEndOfTrie)
 }
@@ -429,3 +473,594 @@ func TestCountPaperDataset(t *testing.T) {
 		assert.Equal(t, test.count, count, "Expected count from %s to %s = %d, got %d", test.lower, test.upper, test.count, count)
 	}
 }
+
+func TestCountError(t *testing.T) {
+	surf := newPaperSURF(t)
+
+	// With D-HasChild truncated to the first two nodes, the key "f" is
+	// found, but moving on from it to "far" fails.
+	truncated, err := surf.DenseHasChild.(*bitmap.Bitmap).Slice(0, 512)
+	assert.Nil(t, err)
+	surf.DenseHasChild = truncated
+
+	_, err = surf.Count([]byte("f"), []byte("t"))
+	assert.NotNil(t, err)
+}
+
+func TestNewInvalidKeys(t *testing.T) {
+	_, err := New([][]byte{[]byte("b"), []byte("a"), []byte("b")}, SURFOptions{})
+	assert.ErrorIs(t, err, ErrDuplicateKey)
+}
+
+func TestNewMemoryLimitExceeded(t *testing.T) {
+	keys := [][]byte{[]byte("ab"), []byte("ac")}
+
+	// Two nodes needed, one available
+	limit := 513
+	_, err := New(keys, SURFOptions{MemoryLimit: &limit})
+
+	var limitErr *MemoryLimitError
+	assert.ErrorIs(t, err, ErrMemoryLimitExceeded)
+	if assert.ErrorAs(t, err, &limitErr) {
+		assert.Equal(t, 2*513, limitErr.Required)
+		assert.Equal(t, 513, limitErr.Available)
+	}
+}
+
+func TestEstimateSize(t *testing.T) {
+	keys := paperKeys()
+
+	size, err := EstimateSize(keys, SURFOptions{})
+	assert.Nil(t, err)
+
+	// Truncated keys are encoded in 8 nodes
+	assert.Equal(t, 8*256, size.DenseLabels)
+	assert.Equal(t, 8*256, size.DenseHasChild)
+	assert.Equal(t, 8, size.DenseIsPrefixKey)
+	assert.Equal(t, 8*513, size.Total())
+
+	// The estimate is exact, so is just enough as a memory limit
+	limit := size.Total()
+	_, err = New(keys, SURFOptions{MemoryLimit: &limit})
+	assert.Nil(t, err)
+
+	limit--
+	_, err = New(keys, SURFOptions{MemoryLimit: &limit})
+	assert.ErrorIs(t, err, ErrMemoryLimitExceeded)
+
+	_, err = EstimateSize([][]byte{[]byte("a"), []byte("a")}, SURFOptions{})
+	assert.ErrorIs(t, err, ErrDuplicateKey)
+}
+
+func TestEstimateSizeEncodingsAndLayouts(t *testing.T) {
+	keys := paperKeys()
+
+	// 7 D-HasChild bits are set, one per non-root node, and 2 D-IsPrefixKey
+	// bits, for "f" and "fas"
+	size, err := EstimateSize(keys, WithHasChildEncoding(EncodingEliasFano), WithIsPrefixKeyEncoding(EncodingEliasFano))
+	assert.Nil(t, err)
+	assert.Equal(t, 8*256, size.DenseLabels)
+	assert.Equal(t, bitmap.EliasFanoSizeBits(8*256, 7), size.DenseHasChild)
+	assert.Equal(t, bitmap.EliasFanoSizeBits(64, 2), size.DenseIsPrefixKey)
+
+	surf, err := New(keys, WithHasChildEncoding(EncodingEliasFano), WithIsPrefixKeyEncoding(EncodingEliasFano))
+	assert.Nil(t, err)
+	assert.Equal(t, surf.DenseHasChild.(*bitmap.EliasFano).SizeBits(), size.DenseHasChild)
+	assert.Equal(t, surf.DenseIsPrefixKey.(*bitmap.EliasFano).SizeBits(), size.DenseIsPrefixKey)
+
+	// Interleaved nodes take 1024 bits each
+	size, err = EstimateSize(keys, WithDenseLayout(LayoutInterleaved))
+	assert.Nil(t, err)
+	assert.Equal(t, 8*512, size.DenseLabels)
+	assert.Equal(t, 8*512, size.DenseHasChild)
+	assert.Equal(t, 8*1024+8, size.Total())
+
+	// Building it needs the plain bitmaps on top
+	peak := 8*513 + 8*1024
+	_, err = New(keys, WithDenseLayout(LayoutInterleaved), WithMemoryLimit(peak))
+	assert.Nil(t, err)
+
+	_, err = New(keys, WithDenseLayout(LayoutInterleaved), WithMemoryLimit(peak-1))
+	var limitErr *MemoryLimitError
+	if assert.ErrorAs(t, err, &limitErr) {
+		assert.Equal(t, peak, limitErr.Required)
+	}
+}
+
+func TestHasPrefix(t *testing.T) {
+	surf := newPaperSURF(t)
+
+	tests := []struct {
+		prefix    []byte
+		hasPrefix bool
+	}{
+		{[]byte(""), true},
+		{[]byte("f"), true},
+		{[]byte("fa"), true},
+		{[]byte("fast"), true},
+		{[]byte("fasten"), true},
+		{[]byte("tr"), true},
+		{[]byte("splice"), true},
+		{[]byte("spa"), true}, // FP, as splice was truncated to s
+		{[]byte("a"), false},
+		{[]byte("fb"), false},
+		{[]byte("tra"), false},
+	}
+
+	for _, test := range tests {
+		hasPrefix, err := surf.HasPrefix(test.prefix)
+		assert.Nil(t, err)
+		assert.Equal(t, test.hasPrefix, hasPrefix, "Prefix %s", test.prefix)
+	}
+
+	empty, err := New([][]byte{}, SURFOptions{})
+	assert.Nil(t, err)
+	hasPrefix, err := empty.HasPrefix([]byte{})
+	assert.Nil(t, err)
+	assert.False(t, hasPrefix)
+}
+
+func TestEmptyKeyAndPrefixChain(t *testing.T) {
+	keys := [][]byte{
+		[]byte("abcd"),
+		[]byte(""),
+		[]byte("ab"),
+		[]byte("a"),
+		[]byte("abc"),
+		[]byte("b"),
+	}
+
+	surf, err := New(keys, SURFOptions{})
+	if err != nil {
+		t.Fatalf("Error creating SuRF store: %v", err)
+	}
+	assert.Nil(t, surf.Validate())
+
+	for _, k := range keys {
+		exists, err := surf.Lookup(k)
+		assert.Nil(t, err)
+		assert.True(t, exists, "Expected key %q to exist; but did not", k)
+
+		hasPrefix, err := surf.HasPrefix(k)
+		assert.Nil(t, err)
+		assert.True(t, hasPrefix, "Expected prefix %q to exist; but did not", k)
+	}
+
+	for _, k := range [][]byte{[]byte("aa"), []byte("abd"), []byte("c"), []byte{0x00}} {
+		exists, err := surf.Lookup(k)
+		assert.Nil(t, err)
+		assert.False(t, exists, "Expected key %q to not exist; but did", k)
+	}
+
+	ranges := []struct {
+		low, high []byte
+		exists    bool
+		count     int
+	}{
+		{[]byte(""), []byte(""), true, 1},
+		{[]byte(""), []byte("a"), true, 2},
+		{[]byte(""), []byte("z"), true, 6},
+		{[]byte{0x00}, []byte("a"), true, 1},
+		{[]byte("a"), []byte("abc"), true, 3},
+		{[]byte("ab"), []byte("ab"), true, 1},
+		{[]byte("abce"), []byte("az"), false, 0},
+		{[]byte("c"), []byte("z"), false, 0},
+	}
+
+	for _, r := range ranges {
+		exists, err := surf.RangeLookup(r.low, r.high)
+		assert.Nil(t, err)
+		assert.Equal(t, r.exists, exists, "RangeLookup(%q, %q)", r.low, r.high)
+
+		count, err := surf.Count(r.low, r.high)
+		assert.Nil(t, err)
+		assert.Equal(t, r.count, count, "Count(%q, %q)", r.low, r.high)
+	}
+
+	stats, err := surf.Stats()
+	assert.Nil(t, err)
+	assert.Equal(t, 6, stats.Keys)
+
+	it := Iterator{
+		Labels:      surf.DenseLabels,
+		HasChild:    surf.DenseHasChild,
+		IsPrefixKey: surf.DenseIsPrefixKey,
+	}
+	for _, expected := range []string{"", "a", "ab", "abc", "abcd", "b"} {
+		key, err := it.Next()
+		assert.Nil(t, err)
+		assert.Equal(t, louds.Key(expected), key)
+	}
+	_, err = it.Next()
+	assert.ErrorIs(t, err, ErrEndOfTrie)
+}
+
+func TestCountPrefixKeyWithNullEdge(t *testing.T) {
+	// The node of the prefix key "a" has an edge 0x00, which must not be
+	// skipped when continuing from "a".
+	keys := [][]byte{[]byte("a"), []byte("a\x00")}
+
+	surf, err := New(keys, SURFOptions{})
+	if err != nil {
+		t.Fatalf("Error creating SuRF store: %v", err)
+	}
+
+	count, err := surf.Count([]byte("a"), []byte("a\x00"))
+	assert.Nil(t, err)
+	assert.Equal(t, 2, count)
+
+	it := surf.acquireIterator()
+	defer releaseIterator(it)
+	key, err := surf.descendOrGreater(it, []byte("a"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("a"), key)
+}
+
+func TestOnlyEmptyKey(t *testing.T) {
+	surf, err := New([][]byte{{}}, SURFOptions{})
+	if err != nil {
+		t.Fatalf("Error creating SuRF store: %v", err)
+	}
+
+	exists, err := surf.Lookup([]byte{})
+	assert.Nil(t, err)
+	assert.True(t, exists)
+
+	exists, err = surf.Lookup([]byte("a"))
+	assert.Nil(t, err)
+	assert.False(t, exists)
+
+	count, err := surf.Count([]byte{}, []byte{0xFF})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, count)
+}
+
+func TestBitmapEncodingsAndLayouts(t *testing.T) {
+	keys := benchmarkKeys(500)
+	plain, err := New(keys)
+	if err != nil {
+		t.Fatalf("Error creating SuRF store: %v", err)
+	}
+
+	queries := batchQueries(keys[:50])
+	ranges := benchmarkRanges(keys[:50])
+
+	encodings := [][]Option{
+		{WithDenseLayout(LayoutInterleaved)},
+		{WithDenseLayout(LayoutInterleaved), WithIsPrefixKeyEncoding(EncodingEliasFano)},
+		{WithLabelsEncoding(EncodingEliasFano)},
+		{WithHasChildEncoding(EncodingEliasFano)},
+		{WithIsPrefixKeyEncoding(EncodingEliasFano)},
+		{
+			WithLabelsEncoding(EncodingEliasFano),
+			WithHasChildEncoding(EncodingEliasFano),
+			WithIsPrefixKeyEncoding(EncodingEliasFano),
+		},
+	}
+
+	for _, opts := range encodings {
+		surf, err := New(keys, opts...)
+		if err != nil {
+			t.Fatalf("Error creating SuRF store: %v", err)
+		}
+		assert.Nil(t, surf.Validate())
+
+		for _, query := range queries {
+			expected, err := plain.Lookup(query)
+			assert.Nil(t, err)
+
+			exists, err := surf.Lookup(query)
+			assert.Nil(t, err)
+			assert.Equal(t, expected, exists, "Lookup of %q", query)
+		}
+
+		for _, r := range ranges {
+			expected, err := plain.Count(r.Low, r.High)
+			assert.Nil(t, err)
+
+			count, err := surf.Count(r.Low, r.High)
+			assert.Nil(t, err)
+			assert.Equal(t, expected, count, "Count of [%q, %q]", r.Low, r.High)
+		}
+	}
+}
+
+// batchQueries returns queries which exercise batch lookups: Stored keys,
+// their prefixes and extensions, as well as keys which are not stored, in
+// both sorted and unsorted order.
+func batchQueries(keys [][]byte) [][]byte {
+	queries := [][]byte{[]byte(""), []byte("a"), []byte("zzz"), []byte{0x00}}
+	for _, key := range keys {
+		queries = append(queries, key, append(slices.Clone(key), 0x00))
+		if len(key) > 0 {
+			queries = append(queries, key[:len(key)-1])
+		}
+	}
+
+	sorted := slices.Clone(queries)
+	slices.SortFunc(sorted, func(a, b []byte) bool {
+		return bytes.Compare(a, b) < 0
+	})
+
+	return append(sorted, queries...)
+}
+
+func TestLookupBatch(t *testing.T) {
+	keySets := [][][]byte{
+		paperKeys(),
+		{
+			[]byte(""),
+			[]byte("a"),
+			[]byte("ab"),
+			[]byte("abc"),
+			[]byte("b"),
+		},
+		{},
+	}
+
+	for _, keys := range keySets {
+		surf, err := New(keys, SURFOptions{})
+		if err != nil {
+			t.Fatalf("Error creating SuRF store: %v", err)
+		}
+
+		queries := batchQueries(keys)
+		out := make([]bool, len(queries))
+		err = surf.LookupBatch(queries, out)
+		assert.Nil(t, err)
+
+		for i, query := range queries {
+			exists, err := surf.Lookup(query)
+			assert.Nil(t, err)
+			assert.Equal(t, exists, out[i], "Batch lookup of %q differs from Lookup", query)
+		}
+	}
+}
+
+func TestLookupBatchShortOutput(t *testing.T) {
+	surf := newPaperSURF(t)
+
+	err := surf.LookupBatch([][]byte{[]byte("f"), []byte("fas")}, make([]bool, 1))
+	assert.NotNil(t, err)
+
+	err = surf.RangeLookupBatch([]Range{{[]byte("a"), []byte("b")}}, nil)
+	assert.NotNil(t, err)
+}
+
+func TestRangeLookupBatch(t *testing.T) {
+	keySets := [][][]byte{
+		paperKeys(),
+		{
+			[]byte(""),
+			[]byte("a"),
+			[]byte("a\x00"),
+			[]byte("ab"),
+			[]byte("b"),
+		},
+	}
+
+	for _, keys := range keySets {
+		surf, err := New(keys, SURFOptions{})
+		if err != nil {
+			t.Fatalf("Error creating SuRF store: %v", err)
+		}
+
+		queries := batchQueries(keys)
+		ranges := make([]Range, 0)
+		for i := 0; i+1 < len(queries); i++ {
+			low, high := queries[i], queries[i+1]
+			if bytes.Compare(low, high) > 0 {
+				low, high = high, low
+			}
+			ranges = append(ranges, Range{low, high}, Range{low, low})
+		}
+
+		out := make([]bool, len(ranges))
+		err = surf.RangeLookupBatch(ranges, out)
+		assert.Nil(t, err)
+
+		for i, r := range ranges {
+			exists, err := surf.RangeLookup(r.Low, r.High)
+			assert.Nil(t, err)
+			assert.Equal(t, exists, out[i], "Batch lookup of range [%q, %q] differs from RangeLookup", r.Low, r.High)
+		}
+	}
+}
+
+// benchmarkKeys returns n unique random keys of length [1, 16] in sorted
+// order.
+func benchmarkKeys(n int) [][]byte {
+	rng := rand.New(rand.NewSource(42))
+
+	seen := make(map[string]bool, n)
+	keys := make([][]byte, 0, n)
+	for len(keys) < n {
+		key := make([]byte, 1+rng.Intn(16))
+		rng.Read(key)
+
+		if seen[string(key)] {
+			continue
+		}
+		seen[string(key)] = true
+		keys = append(keys, key)
+	}
+
+	slices.SortFunc(keys, func(a, b []byte) bool {
+		return bytes.Compare(a, b) < 0
+	})
+
+	return keys
+}
+
+// benchmarkRanges returns ranges between consecutive keys.
+func benchmarkRanges(keys [][]byte) []Range {
+	ranges := make([]Range, len(keys)-1)
+	for i := range ranges {
+		ranges[i] = Range{keys[i], keys[i+1]}
+	}
+
+	return ranges
+}
+
+func BenchmarkLookup(b *testing.B) {
+	keys := benchmarkKeys(10_000)
+	surf, err := New(keys)
+	if err != nil {
+		b.Fatalf("Error creating SuRF store: %v", err)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, key := range keys {
+			surf.Lookup(key)
+		}
+	}
+}
+
+func BenchmarkLookupEncodings(b *testing.B) {
+	keys := benchmarkKeys(10_000)
+
+	for _, enc := range []BitmapEncoding{EncodingPlain, EncodingEliasFano} {
+		surf, err := New(keys, WithHasChildEncoding(enc), WithIsPrefixKeyEncoding(enc))
+		if err != nil {
+			b.Fatalf("Error creating SuRF store: %v", err)
+		}
+
+		b.Run(enc.String(), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				for _, key := range keys {
+					surf.Lookup(key)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkLookupLayouts(b *testing.B) {
+	keys := benchmarkKeys(10_000)
+
+	for _, layout := range []DenseLayout{LayoutSeparate, LayoutInterleaved} {
+		surf, err := New(keys, WithDenseLayout(layout))
+		if err != nil {
+			b.Fatalf("Error creating SuRF store: %v", err)
+		}
+
+		b.Run(layout.String(), func(b *testing.B) {
+			b.Run("Lookup", func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					for _, key := range keys {
+						surf.Lookup(key)
+					}
+				}
+			})
+
+			b.Run("LookupBatch", func(b *testing.B) {
+				out := make([]bool, len(keys))
+				for i := 0; i < b.N; i++ {
+					surf.LookupBatch(keys, out)
+				}
+			})
+		})
+	}
+}
+
+func BenchmarkLookupBatch(b *testing.B) {
+	keys := benchmarkKeys(10_000)
+	surf, err := New(keys)
+	if err != nil {
+		b.Fatalf("Error creating SuRF store: %v", err)
+	}
+	out := make([]bool, len(keys))
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		surf.LookupBatch(keys, out)
+	}
+}
+
+func BenchmarkRangeLookup(b *testing.B) {
+	keys := benchmarkKeys(10_000)
+	surf, err := New(keys)
+	if err != nil {
+		b.Fatalf("Error creating SuRF store: %v", err)
+	}
+	ranges := benchmarkRanges(keys)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, r := range ranges {
+			surf.RangeLookup(r.Low, r.High)
+		}
+	}
+}
+
+func BenchmarkRangeLookupBatch(b *testing.B) {
+	keys := benchmarkKeys(10_000)
+	surf, err := New(keys)
+	if err != nil {
+		b.Fatalf("Error creating SuRF store: %v", err)
+	}
+	ranges := benchmarkRanges(keys)
+	out := make([]bool, len(ranges))
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		surf.RangeLookupBatch(ranges, out)
+	}
+}
+
+func TestLookupAllocations(t *testing.T) {
+	if raceEnabled {
+		t.Skip("Pooled iterators are dropped at random with the race detector")
+	}
+
+	surf := newPaperSURF(t)
+
+	keys := [][]byte{
+		[]byte("f"),       // Prefix key
+		[]byte("fasten"),  // Leaf
+		[]byte("fastest"), // Truncated match
+		[]byte("fa"),      // Not a prefix key
+		[]byte("g"),       // Missing edge
+		[]byte(""),
+	}
+	for _, key := range keys {
+		allocs := testing.AllocsPerRun(100, func() {
+			surf.Lookup(key)
+		})
+		assert.Equal(t, 0.0, allocs, "Lookup(%q) allocated", key)
+	}
+
+	ranges := []Range{
+		{[]byte("f"), []byte("f")},       // Exact match
+		{[]byte("fb"), []byte("s")},      // Next greater key is a leaf
+		{[]byte("fa"), []byte("fas")},    // Next greater key is a prefix key
+		{[]byte("tp"), []byte("tq")},     // Next greater key out of range
+		{[]byte("trz"), []byte("zarty")}, // End of trie
+	}
+	for _, r := range ranges {
+		allocs := testing.AllocsPerRun(100, func() {
+			surf.RangeLookup(r.Low, r.High)
+		})
+		assert.Equal(t, 0.0, allocs, "RangeLookup(%q, %q) allocated", r.Low, r.High)
+
+		allocs = testing.AllocsPerRun(100, func() {
+			surf.Count(r.Low, r.High)
+		})
+		assert.Equal(t, 0.0, allocs, "Count(%q, %q) allocated", r.Low, r.High)
+	}
+}
+
+func TestReleaseIterator(t *testing.T) {
+	surf := newPaperSURF(t)
+
+	it := surf.acquireIterator()
+	assert.NotNil(t, it.Labels)
+
+	releaseIterator(it)
+	assert.Nil(t, it.Labels)
+	assert.Nil(t, it.HasChild)
+	assert.Nil(t, it.IsPrefixKey)
+}
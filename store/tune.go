@@ -0,0 +1,282 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+)
+
+// ErrTargetUnreachable indicates that the configurations considered by Tune do
+// not meet the requested target.
+var ErrTargetUnreachable = errors.New("No configuration meets the target")
+
+// ErrInvalidTarget indicates that a tuning target was specified incorrectly.
+var ErrInvalidTarget = errors.New("Invalid tuning target")
+
+// tuneSampleQueries is the maximum number of queries Tune measures the false
+// positive rate with.
+const tuneSampleQueries = 1024
+
+// tuneSeed seeds the sampling of queries, such that Tune's recommendations are
+// reproducible.
+const tuneSeed = 42
+
+// Target specifies the goal which Tune optimises options for.
+//
+// At least one of the two fields must be set. If both are, both must be met.
+type Target struct {
+	// MaxBitsPerKey is the maximum number of bits per key the store may
+	// use, or 0 if space is not constrained.
+	MaxBitsPerKey float64
+
+	// MaxFPR is the maximum false-positive rate, in [0, 1], of point
+	// queries, or nil if precision is not constrained. A rate of 0 asks
+	// for no false positives on the sample queries at all.
+	MaxFPR *float64
+}
+
+// candidate is a configuration considered by Tune, along with its measured
+// properties.
+type candidate struct {
+	labels, hasChild, isPrefixKey BitmapEncoding
+	layout                        DenseLayout
+
+	bitsPerKey float64
+	fpr        float64
+}
+
+// tuneCandidates returns the configurations Tune considers.
+//
+// Only options which change the filter are worth considering. These are the
+// encoding of each bitmap and the dense layout, in all valid combinations. As
+// neither LOUDS-SPARSE nor suffixes are implemented yet, R, HashBits and
+// RealBits have no effect so far. They are to be added as they come to have
+// one.
+func tuneCandidates() []candidate {
+	encodings := []BitmapEncoding{EncodingPlain, EncodingEliasFano}
+
+	var candidates []candidate
+	for _, layout := range []DenseLayout{LayoutSeparate, LayoutInterleaved} {
+		for _, labels := range encodings {
+			for _, hasChild := range encodings {
+				for _, isPrefixKey := range encodings {
+					c := candidate{labels: labels, hasChild: hasChild, isPrefixKey: isPrefixKey, layout: layout}
+					options := c.options()
+					if options.validate() == nil {
+						candidates = append(candidates, c)
+					}
+				}
+			}
+		}
+	}
+
+	return candidates
+}
+
+// Tune recommends options for storing the given keys, such that the target is
+// met.
+//
+// For each configuration it considers, space is computed with EstimateSize on
+// the full set of keys. The false-positive rate is measured empirically on a
+// store of all keys, by looking up those of the sample queries which are not
+// stored. The sample queries should thus resemble the queries the store will
+// face in practice.
+//
+// If only MaxBitsPerKey is set, the configuration meeting it with the lowest
+// false-positive rate is chosen. Otherwise the one meeting the target with
+// the fewest bits per key is. Remaining ties are broken in favour of fewer bitmaps
+// encoded with Elias-Fano, which are slower to query, and then of the
+// separate layout.
+//
+// Only the options Tune considers are set in the recommendation, the others
+// are left at their defaults. The configurations considered for now do not
+// affect the false-positive rate, see tuneCandidates, so it merely tells
+// whether an FPR target can be met at all.
+//
+// An error wrapping ErrInvalidTarget is returned if the target is malformed,
+// or if it specifies a false-positive rate but no negative sample queries
+// were given. ErrTargetUnreachable is returned if no configuration meets the
+// target. Invalid keys lead to the same errors as for New.
+func Tune(rawKeys [][]byte, sampleQueries [][]byte, target Target) (SURFOptions, error) {
+	if target.MaxBitsPerKey < 0 {
+		return SURFOptions{}, fmt.Errorf("%w: MaxBitsPerKey must not be negative. Was %f", ErrInvalidTarget, target.MaxBitsPerKey)
+	}
+	if target.MaxFPR != nil && (*target.MaxFPR < 0 || *target.MaxFPR > 1) {
+		return SURFOptions{}, fmt.Errorf("%w: MaxFPR must be in [0, 1]. Was %f", ErrInvalidTarget, *target.MaxFPR)
+	}
+	if target.MaxBitsPerKey == 0 && target.MaxFPR == nil {
+		return SURFOptions{}, fmt.Errorf("%w: Neither MaxBitsPerKey nor MaxFPR set", ErrInvalidTarget)
+	}
+
+	keys, err := prepareKeys(rawKeys)
+	if err != nil {
+		return SURFOptions{}, err
+	}
+
+	rng := rand.New(rand.NewSource(tuneSeed))
+	sampleQueries = sample(rng, sampleQueries, tuneSampleQueries)
+
+	// Only queries for keys which are not stored can produce false
+	// positives.
+	stored := make(map[string]bool, len(rawKeys))
+	for _, k := range rawKeys {
+		stored[string(k)] = true
+	}
+	negatives := make([][]byte, 0, len(sampleQueries))
+	for _, q := range sampleQueries {
+		if !stored[string(q)] {
+			negatives = append(negatives, q)
+		}
+	}
+
+	if target.MaxFPR != nil && len(negatives) == 0 {
+		return SURFOptions{}, fmt.Errorf("%w: No negative sample queries to measure false-positive rate with", ErrInvalidTarget)
+	}
+
+	// The candidates share the same trie, and thus the same false-positive
+	// rate, so a single store suffices to measure it.
+	plain := plainSize(keys)
+	fpr, err := measureFPR(rawKeys, plain, negatives)
+	if err != nil {
+		return SURFOptions{}, err
+	}
+
+	var best *candidate
+	for _, c := range tuneCandidates() {
+		c := c
+
+		c.bitsPerKey = float64(estimateSize(keys, plain, c.options()).Total())
+		if len(keys) > 0 {
+			c.bitsPerKey /= float64(len(keys))
+		}
+		c.fpr = fpr
+
+		if c.meets(target) && (best == nil || c.better(best, target)) {
+			best = &c
+		}
+	}
+
+	if best == nil {
+		return SURFOptions{}, ErrTargetUnreachable
+	}
+
+	options := best.options()
+	return SURFOptions{
+		LabelsEncoding:      options.LabelsEncoding,
+		HasChildEncoding:    options.HasChildEncoding,
+		IsPrefixKeyEncoding: options.IsPrefixKeyEncoding,
+		DenseLayout:         options.DenseLayout,
+	}, nil
+}
+
+// measureFPR returns the false-positive rate of a store of the given keys, of
+// the given plain size, on the given negative queries.
+func measureFPR(keys [][]byte, plain Size, negatives [][]byte) (float64, error) {
+	if len(negatives) == 0 {
+		return 0, nil
+	}
+
+	// Plain bitmaps need exactly their estimated size, so will not exceed
+	// the memory limit.
+	limit := plain.Total()
+	surf, err := New(keys, WithMemoryLimit(limit))
+	if err != nil {
+		return 0, err
+	}
+
+	falsePositives := 0
+	for _, q := range negatives {
+		exists, err := surf.Lookup(q)
+		if err != nil {
+			return 0, err
+		}
+
+		if exists {
+			falsePositives++
+		}
+	}
+
+	return float64(falsePositives) / float64(len(negatives)), nil
+}
+
+// options returns the candidate's options, with defaults set for all others.
+func (c *candidate) options() SURFOptions {
+	options := SURFOptions{
+		LabelsEncoding:      &c.labels,
+		HasChildEncoding:    &c.hasChild,
+		IsPrefixKeyEncoding: &c.isPrefixKey,
+		DenseLayout:         &c.layout,
+	}
+	options.setDefaults()
+
+	return options
+}
+
+// meets checks whether the candidate meets all constraints of the target.
+func (c *candidate) meets(target Target) bool {
+	if target.MaxBitsPerKey != 0 && c.bitsPerKey > target.MaxBitsPerKey {
+		return false
+	}
+
+	if target.MaxFPR != nil && c.fpr > *target.MaxFPR {
+		return false
+	}
+
+	return true
+}
+
+// better checks whether the candidate is preferable to another one, both of
+// which meet the target.
+func (c *candidate) better(other *candidate, target Target) bool {
+	// If only a space budget is set we optimise for precision, as space
+	// within the budget is of no concern. Otherwise we optimise for space.
+	if target.MaxFPR == nil {
+		if c.fpr != other.fpr {
+			return c.fpr < other.fpr
+		}
+	} else if c.bitsPerKey != other.bitsPerKey {
+		return c.bitsPerKey < other.bitsPerKey
+	}
+
+	if c.eliasFanoBitmaps() != other.eliasFanoBitmaps() {
+		return c.eliasFanoBitmaps() < other.eliasFanoBitmaps()
+	}
+
+	return c.layout == LayoutSeparate && other.layout != LayoutSeparate
+}
+
+// eliasFanoBitmaps returns the number of bitmaps the candidate encodes with
+// Elias-Fano.
+func (c *candidate) eliasFanoBitmaps() int {
+	n := 0
+	for _, enc := range []BitmapEncoding{c.labels, c.hasChild, c.isPrefixKey} {
+		if enc == EncodingEliasFano {
+			n++
+		}
+	}
+
+	return n
+}
+
+// sample returns up to n randomly chosen elements of xs, in their original
+// order.
+func sample(rng *rand.Rand, xs [][]byte, n int) [][]byte {
+	if len(xs) <= n {
+		return xs
+	}
+
+	picked := rng.Perm(len(xs))[:n]
+	chosen := make([]bool, len(xs))
+	for _, i := range picked {
+		chosen[i] = true
+	}
+
+	out := make([][]byte, 0, n)
+	for i, x := range xs {
+		if chosen[i] {
+			out = append(out, x)
+		}
+	}
+
+	return out
+}
@@ -0,0 +1,94 @@
+package store
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTune(t *testing.T) {
+	keys := paperKeys()
+
+	queries := [][]byte{
+		[]byte("fatter"), // False positive
+		[]byte("sorry"),  // False positive
+		[]byte("fasi"),   // True negative
+		[]byte("x"),      // True negative
+		[]byte("fat"),    // Stored, so not considered
+	}
+
+	// Elias-Fano pays off for the sparse labels and hasChild bitmaps, but
+	// not for the tiny isPrefixKey one.
+	options, err := Tune(keys, queries, Target{MaxFPR: maxFPR(0.5)})
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, EncodingEliasFano, *options.LabelsEncoding)
+	assert.Equal(t, EncodingEliasFano, *options.HasChildEncoding)
+	assert.Equal(t, EncodingPlain, *options.IsPrefixKeyEncoding)
+	assert.Equal(t, LayoutSeparate, *options.DenseLayout)
+	assert.Nil(t, options.R)
+	assert.Nil(t, options.HashBits)
+	assert.Nil(t, options.RealBits)
+
+	smallest, err := EstimateSize(keys, options)
+	assert.Nil(t, err)
+
+	// Paper dataset needs 8 nodes, so 8 * 513 / 11 bits per key when
+	// plain. Encodings and layout do not change the false-positive rate,
+	// so the ties are broken in favour of plain bitmaps in the separate
+	// layout.
+	plainBitsPerKey := float64(8*513) / 11
+	options, err = Tune(keys, queries, Target{MaxBitsPerKey: plainBitsPerKey})
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, EncodingPlain, *options.LabelsEncoding)
+	assert.Equal(t, EncodingPlain, *options.HasChildEncoding)
+	assert.Equal(t, EncodingPlain, *options.IsPrefixKeyEncoding)
+	assert.Equal(t, LayoutSeparate, *options.DenseLayout)
+
+	// Space is estimated on all keys, not just a sample of them.
+	minBitsPerKey := float64(smallest.Total()) / float64(len(keys))
+	_, err = Tune(keys, queries, Target{MaxBitsPerKey: minBitsPerKey})
+	assert.Nil(t, err)
+
+	_, err = Tune(keys, queries, Target{MaxBitsPerKey: minBitsPerKey - 0.01})
+	assert.ErrorIs(t, err, ErrTargetUnreachable)
+
+	_, err = Tune(keys, queries, Target{MaxFPR: maxFPR(0.25)})
+	assert.ErrorIs(t, err, ErrTargetUnreachable)
+
+	// A rate of 0 is a target of its own, rather than no target at all.
+	_, err = Tune(keys, queries, Target{MaxFPR: maxFPR(0)})
+	assert.ErrorIs(t, err, ErrTargetUnreachable)
+
+	_, err = Tune(keys, queries[2:], Target{MaxFPR: maxFPR(0)})
+	assert.Nil(t, err)
+}
+
+func TestTuneInvalidTarget(t *testing.T) {
+	keys := [][]byte{[]byte("a"), []byte("b")}
+
+	_, err := Tune(keys, keys, Target{})
+	assert.ErrorIs(t, err, ErrInvalidTarget)
+
+	_, err = Tune(keys, keys, Target{MaxFPR: maxFPR(1.5)})
+	assert.ErrorIs(t, err, ErrInvalidTarget)
+
+	_, err = Tune(keys, keys, Target{MaxBitsPerKey: -1})
+	assert.ErrorIs(t, err, ErrInvalidTarget)
+
+	// All queries are stored keys, so no false-positive rate can be measured
+	_, err = Tune(keys, keys, Target{MaxFPR: maxFPR(0.1)})
+	assert.ErrorIs(t, err, ErrInvalidTarget)
+
+	_, err = Tune([][]byte{[]byte("a"), []byte("a")}, keys, Target{MaxBitsPerKey: 1000})
+	assert.ErrorIs(t, err, ErrDuplicateKey)
+}
+
+// maxFPR returns a pointer to the given false-positive rate, for use as
+// Target.MaxFPR.
+func maxFPR(rate float64) *float64 {
+	return &rate
+}
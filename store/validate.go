@@ -0,0 +1,120 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/Lavode/surf/bitmap"
+)
+
+// ErrInvalidStructure indicates that the encoding of a SuRF store violates one
+// of the LOUDS invariants.
+var ErrInvalidStructure = errors.New("Invalid SuRF structure")
+
+// Validate checks the encoding of the store for structural integrity.
+//
+// It checks the following invariants of the LOUDS-DENSE encoding:
+//   - D-Labels and D-HasChild consist of one 256-bit block per node, and are of
+//     equal length.
+//   - Within each node, the edges with a child are a subset of the edges.
+//   - Each node other than the root is the child of exactly one edge, so the
+//     number of set D-HasChild bits is the number of nodes minus one.
+//   - Nodes are in level order, so each node is the child of an edge of a
+//     node preceding it. This ensures that the trie is free of cycles.
+//   - D-IsPrefixKey holds one bit per node, with all bits past the last node
+//     being zero.
+//
+// SuRF does not store suffixes yet, so there are none to check.
+//
+// On the first violation, an error wrapping ErrInvalidStructure and describing
+// the violation is returned.
+func (surf *SURF) Validate() error {
+	if surf.DenseLabels == nil || surf.DenseHasChild == nil || surf.DenseIsPrefixKey == nil {
+		return fmt.Errorf("%w: Missing LOUDS-DENSE bitmap", ErrInvalidStructure)
+	}
+
+	labelsLength := surf.DenseLabels.Len()
+	if labelsLength == 0 || labelsLength%256 != 0 {
+		return fmt.Errorf("%w: D-Labels length %d is not a positive multiple of 256", ErrInvalidStructure, labelsLength)
+	}
+	nodes := labelsLength / 256
+
+	if surf.DenseHasChild.Len() != labelsLength {
+		return fmt.Errorf(
+			"%w: D-HasChild length %d differs from D-Labels length %d",
+			ErrInvalidStructure,
+			surf.DenseHasChild.Len(),
+			labelsLength,
+		)
+	}
+
+	// Edges with a child must be edges in the first place.
+	labels, err := bitmap.ToBitmap(surf.DenseLabels)
+	if err != nil {
+		return fmt.Errorf("Error decoding D-Labels: %v", err)
+	}
+	hasChild, err := bitmap.ToBitmap(surf.DenseHasChild)
+	if err != nil {
+		return fmt.Errorf("Error decoding D-HasChild: %v", err)
+	}
+	if bit, found := hasChild.AndNot(labels).NextOne(0); found {
+		return fmt.Errorf(
+			"%w: D-HasChild set for edge %d of node %d, which is not set in D-Labels",
+			ErrInvalidStructure,
+			bit%256,
+			bit/256,
+		)
+	}
+
+	// Number of set D-HasChild bits encountered so far. The n-th node is
+	// the child of the n-th set bit, so must follow it.
+	children := 0
+	for bit, found := hasChild.NextOne(0); found; bit, found = hasChild.NextOne(bit + 1) {
+		// All nodes up to and including the one of this bit must be
+		// children of preceding bits.
+		if bit/256 > children {
+			return fmt.Errorf(
+				"%w: Node %d is not the child of an edge of a preceding node",
+				ErrInvalidStructure,
+				children+1,
+			)
+		}
+
+		children++
+	}
+
+	if children != nodes-1 {
+		return fmt.Errorf(
+			"%w: D-HasChild has %d bits set, but there are %d non-root nodes",
+			ErrInvalidStructure,
+			children,
+			nodes-1,
+		)
+	}
+
+	// D-IsPrefixKey is padded to a multiple of 64 bits
+	prefixKeyLength := surf.DenseIsPrefixKey.Len()
+	if prefixKeyLength < nodes || prefixKeyLength >= nodes+64 {
+		return fmt.Errorf(
+			"%w: D-IsPrefixKey length %d does not match node count %d",
+			ErrInvalidStructure,
+			prefixKeyLength,
+			nodes,
+		)
+	}
+
+	bit, found, err := bitmap.NextOne(surf.DenseIsPrefixKey, nodes)
+	if err != nil {
+		return fmt.Errorf("Error finding next 1-bit of D-IsPrefixKey from %d: %v", nodes, err)
+	}
+	if found {
+		return fmt.Errorf(
+			"%w: D-IsPrefixKey has trailing bit %d set, past the last node %d",
+			ErrInvalidStructure,
+			bit,
+			nodes-1,
+		)
+	}
+
+	return nil
+}
@@ -0,0 +1,77 @@
+package store
+
+import (
+	"testing"
+
+	"github.com/Lavode/surf/bitmap"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidate(t *testing.T) {
+	assert.Nil(t, newPaperSURF(t).Validate())
+
+	surf, err := New([][]byte{}, SURFOptions{})
+	assert.Nil(t, err)
+	assert.Nil(t, surf.Validate())
+}
+
+func TestValidateCorrupted(t *testing.T) {
+	tests := []struct {
+		name    string
+		corrupt func(surf *SURF)
+	}{
+		{"HasChild without label", func(surf *SURF) {
+			// Root has no edge 'a'
+			assert.Nil(t, surf.DenseHasChild.(*bitmap.Bitmap).Set('a'))
+		}},
+		{"HasChild count", func(surf *SURF) {
+			// Root's edge 'f' has a child
+			assert.Nil(t, surf.DenseHasChild.(*bitmap.Bitmap).Unset('f'))
+		}},
+		{"Labels length", func(surf *SURF) {
+			surf.DenseLabels = bitmap.New(8*256+64, 16*256)
+		}},
+		{"HasChild length", func(surf *SURF) {
+			surf.DenseHasChild = bitmap.New(9*256, 9*256)
+		}},
+		{"IsPrefixKey length", func(surf *SURF) {
+			surf.DenseIsPrefixKey = bitmap.New(128, 128)
+		}},
+		{"IsPrefixKey trailing bit", func(surf *SURF) {
+			// Nodes 1 and 6 are prefix keys, bit 8 is past the last
+			// node.
+			isPrefixKey := bitmap.New(64, 64)
+			for _, bit := range []int{1, 6, 8} {
+				assert.Nil(t, isPrefixKey.Set(bit))
+			}
+			surf.DenseIsPrefixKey = isPrefixKey
+		}},
+	}
+
+	for _, test := range tests {
+		surf := newPaperSURF(t)
+		test.corrupt(surf)
+
+		assert.ErrorIs(t, surf.Validate(), ErrInvalidStructure, test.name)
+	}
+}
+
+func TestValidateLevelOrder(t *testing.T) {
+	// Two nodes, with the only edge with a child being in the second node,
+	// pointing to itself.
+	labels := bitmap.New(512, 512)
+	hasChild := bitmap.New(512, 512)
+	assert.Nil(t, labels.Set('a'))
+	assert.Nil(t, labels.Set(256+'b'))
+	assert.Nil(t, hasChild.Set(256+'b'))
+
+	surf := &SURF{
+		DenseLabels:      labels,
+		DenseHasChild:    hasChild,
+		DenseIsPrefixKey: bitmap.New(64, 64),
+	}
+
+	err := surf.Validate()
+	assert.ErrorIs(t, err, ErrInvalidStructure)
+	assert.ErrorContains(t, err, "Node 1 is not the child of an edge of a preceding node")
+}